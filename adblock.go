@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// rgxCosmeticRule находит косметические правила и правила-скрипты (##, #@#, #?#, #$#, #%#, $$, $@$ и т.п.)
+var rgxCosmeticRule = regexp.MustCompile(`#@?[?$%]?#|\$@?\$`)
+
+// adblockModifiers модификаторы, которые не сужают правило блокировки домена настолько,
+// чтобы его нельзя было перенести в список доменов целиком
+var adblockModifiers = map[string]bool{
+	"important":    true,
+	"third-party":  true,
+	"~third-party": true,
+	"3p":           true,
+	"~3p":          true,
+	"first-party":  true,
+	"~first-party": true,
+	"1p":           true,
+	"~1p":          true,
+	"document":     true,
+	"doc":          true,
+	"all":          true,
+}
+
+// adblockStats счётчики правил фильтра AdBlock, которые не удалось перенести в списки
+type adblockStats struct {
+	cosmetic    int // Косметические правила и правила-скрипты
+	unsupported int // Правила с неподдерживаемыми модификаторами или не для всего домена
+}
+
+// parseAdblockFilter извлекает домены из фильтров в синтаксисе AdBlock Plus / AdGuard:
+// правила блокировки "||example.com^" попадают в список источника, исключения "@@||example.com^" -
+// в список исключений категории. Блокировка домена в AdBlock распространяется на поддомены,
+// поэтому домены записываются как "*.example.com"
+func parseAdblockFilter(input io.Reader, source Source, emit func(Entry)) error {
+	var stats adblockStats
+	err := scanLines(input, func(line string) {
+		line = strings.TrimSpace(line)
+
+		// Пропускаем пустые строки, комментарии и заголовок фильтра ([Adblock Plus 2.0])
+		if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+			return
+		}
+
+		// Косметические правила скрывают элементы страниц и к доменам отношения не имеют
+		if rgxCosmeticRule.MatchString(line) {
+			stats.cosmetic++
+			return
+		}
+
+		// Исключения (@@) разрешают домен, который мог быть заблокирован другими правилами
+		exclude := false
+		if strings.HasPrefix(line, "@@") {
+			exclude = true
+			line = line[2:]
+		}
+
+		domain, ok := parseAdblockRule(line)
+		if !ok {
+			stats.unsupported++
+			return
+		}
+		emit(Entry{Value: "*." + domain, Exclude: exclude})
+	})
+	if err != nil {
+		return err
+	}
+
+	if stats.cosmetic > 0 || stats.unsupported > 0 {
+		logInfo.Printf("source '%s': skipped %d cosmetic rules and %d rules with unsupported syntax or modifiers", source.URL, stats.cosmetic, stats.unsupported)
+	}
+	return nil
+}
+
+// parseAdblockRule извлекает домен из сетевого правила вида "||example.com^$modifiers".
+// Правила с путём, шаблонами, регулярными выражениями или сужающими модификаторами не поддерживаются
+func parseAdblockRule(rule string) (string, bool) {
+	// Правило должно блокировать домен целиком
+	if !strings.HasPrefix(rule, "||") {
+		return "", false
+	}
+	rule = rule[2:]
+
+	// Отделяем модификаторы и проверяем, что все они поддерживаются
+	if i := strings.LastIndex(rule, "$"); i >= 0 {
+		for _, modifier := range strings.Split(rule[i+1:], ",") {
+			if !adblockModifiers[strings.ToLower(strings.TrimSpace(modifier))] {
+				return "", false
+			}
+		}
+		rule = rule[:i]
+	}
+
+	// После домена допускается только разделитель "^" (и необязательный "|" в конце)
+	rule = strings.TrimSuffix(rule, "|")
+	domain := strings.TrimSuffix(rule, "^")
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || strings.ContainsAny(domain, "*/^|:") || !strings.Contains(domain, ".") {
+		return "", false
+	}
+
+	domain = strings.ToLower(domain)
+	if !rgxDomain.MatchString(domain) {
+		return "", false
+	}
+	return domain, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseAdblockRule(t *testing.T) {
+	tests := []struct {
+		rule   string
+		domain string // Пустая строка - правило не поддерживается
+	}{
+		{rule: "||a.com^", domain: "a.com"},
+		{rule: "||A.com^|", domain: "a.com"},
+		{rule: "||a.com", domain: "a.com"},
+		{rule: "||a.com^$third-party", domain: "a.com"},
+		{rule: "||a.com^$important,~3p", domain: "a.com"},
+		{rule: "||a.com^$document", domain: "a.com"},
+		{rule: "||a.com^$domain=b.com", domain: ""},
+		{rule: "||a.com^$third-party,domain=b.com", domain: ""},
+		{rule: "||a.com^$popup", domain: ""},
+		{rule: "||a.com^$script", domain: ""},
+		{rule: "||a.com/ads^", domain: ""},
+		{rule: "||ads*.a.com^", domain: ""},
+		{rule: "|https://a.com^", domain: ""},
+		{rule: "/ads[0-9]+\\.a\\.com/", domain: ""},
+		{rule: "a.com", domain: ""},
+		{rule: "||localhost^", domain: ""},
+	}
+	for _, test := range tests {
+		domain, ok := parseAdblockRule(test.rule)
+		if ok != (test.domain != "") || domain != test.domain {
+			t.Errorf("rule '%s': expected '%s', got '%s' (ok=%t)", test.rule, test.domain, domain, ok)
+		}
+	}
+}
+
+func TestParseAdblockFilter(t *testing.T) {
+	const filter = `[Adblock Plus 2.0]
+! Title: test
+||a.com^
+@@||a.com^
+||b.com^$third-party
+||c.com^$domain=d.com
+example.com##.banner
+example.com#@#.banner
+||e.com^$popup
+/banner\d+/
+`
+	var entries []string
+	err := parseAdblockFilter(strings.NewReader(filter), Source{URL: "test"}, func(entry Entry) {
+		entries = append(entries, fmt.Sprintf("%s exclude=%t", entry.Value, entry.Exclude))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"*.a.com exclude=false", "*.a.com exclude=true", "*.b.com exclude=false"}
+	if fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, entries)
+	}
+}
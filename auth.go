@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// BasicAuth логин и пароль для HTTP Basic аутентификации
+type BasicAuth struct {
+	Username string `json:"username"` // Имя пользователя (поддерживает переменные окружения: ${VAR})
+	Password string `json:"password"` // Пароль (поддерживает переменные окружения: ${VAR})
+}
+
+// requestOptions заголовки и учётные данные, добавляемые к запросам источника
+type requestOptions struct {
+	Header   http.Header // Дополнительные заголовки (включая User-Agent)
+	Username string      // Имя пользователя для Basic аутентификации
+	Password string      // Пароль для Basic аутентификации
+	HasAuth  bool        // true, если нужно добавить Basic аутентификацию
+}
+
+// apply добавляет заголовки и учётные данные в запрос
+func (o *requestOptions) apply(req *http.Request) {
+	if o == nil {
+		return
+	}
+	for name, values := range o.Header {
+		req.Header[name] = values
+	}
+	if o.HasAuth {
+		req.SetBasicAuth(o.Username, o.Password)
+	}
+}
+
+// expandSecrets подставляет в value переменные окружения вида ${VAR} или $VAR.
+// Отсутствующая переменная - ошибка, чтобы не отправлять на сервер пустой токен
+func expandSecrets(value string) (string, error) {
+	var missing []string
+	expanded := os.Expand(value, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) != 0 {
+		return "", fmt.Errorf("environment variable(s) %s not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// RequestOptions собирает заголовки и учётные данные источника, подставляя переменные окружения
+func (s Source) RequestOptions() (*requestOptions, error) {
+	options := &requestOptions{Header: http.Header{}}
+
+	for name, value := range s.Headers {
+		expanded, err := expandSecrets(value)
+		if err != nil {
+			return nil, fmt.Errorf("header '%s': %v", name, err)
+		}
+		options.Header.Set(name, expanded)
+	}
+
+	if s.UserAgent != "" {
+		userAgent, err := expandSecrets(s.UserAgent)
+		if err != nil {
+			return nil, fmt.Errorf("userAgent: %v", err)
+		}
+		options.Header.Set("User-Agent", userAgent)
+	}
+
+	// Явно указанные учётные данные имеют приоритет над .netrc
+	if s.BasicAuth != nil {
+		username, err := expandSecrets(s.BasicAuth.Username)
+		if err != nil {
+			return nil, fmt.Errorf("basicAuth.username: %v", err)
+		}
+		password, err := expandSecrets(s.BasicAuth.Password)
+		if err != nil {
+			return nil, fmt.Errorf("basicAuth.password: %v", err)
+		}
+		options.Username, options.Password, options.HasAuth = username, password, true
+	} else if s.Netrc && options.Header.Get("Authorization") == "" {
+		login, password, ok, err := lookupNetrc(hostnameOf(s.URL))
+		if err != nil {
+			return nil, fmt.Errorf("netrc: %v", err)
+		}
+		if ok {
+			options.Username, options.Password, options.HasAuth = login, password, true
+		} else {
+			logWarn.Printf("no .netrc credentials found for '%s'", hostnameOf(s.URL))
+		}
+	}
+
+	return options, nil
+}
+
+// maxRedirects максимальное количество перенаправлений (как у http.Client по умолчанию)
+const maxRedirects = 10
+
+// checkRedirect не отправляет заголовки источника на другой хост при перенаправлении. Go сам убирает
+// только Authorization и Cookie, а токен может быть и в другом заголовке (например, PRIVATE-TOKEN)
+func (s Source) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+		for name := range s.Headers {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// relatedSource возвращает копию источника для скачивания файла location, на который он ссылается (хеш, подпись,
+// include:). Заголовки и учётные данные источника отправляются только на его собственный хост,
+// чтобы токен приватного репозитория не попал на чужой сервер
+func (s Source) relatedSource(location string) Source {
+	related := s
+	related.URL = location
+	if !strings.EqualFold(hostnameOf(location), hostnameOf(s.URL)) {
+		related.Headers, related.BasicAuth, related.Netrc = nil, nil, false
+	}
+	return related
+}
+
+// netrcPath возвращает путь к файлу .netrc: из переменной NETRC или из домашней директории
+func netrcPath() (string, error) {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name), nil
+}
+
+// lookupNetrc ищет в файле .netrc логин и пароль для хоста host (или запись default)
+func lookupNetrc(host string) (string, string, bool, error) {
+	path, err := netrcPath()
+	if err != nil {
+		return "", "", false, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Как curl и git, отсутствие .netrc считаем отсутствием учётных данных
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	defer file.Close()
+
+	// Разбиваем файл на токены, пропуская комментарии
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", false, err
+	}
+
+	// Перебираем записи machine/default
+	var login, password, defaultLogin, defaultPassword string
+	var inMachine, matched, inDefault, hasDefault bool
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if matched {
+				return login, password, true, nil
+			}
+			inDefault = false
+			inMachine = i+1 < len(tokens) && strings.EqualFold(tokens[i+1], host)
+			i++
+			if inMachine {
+				matched = true
+			}
+		case "default":
+			if matched {
+				return login, password, true, nil
+			}
+			inMachine, inDefault, hasDefault = false, true, true
+		case "login", "password", "account":
+			if i+1 >= len(tokens) {
+				break
+			}
+			value := tokens[i+1]
+			switch {
+			case inMachine && tokens[i] == "login":
+				login = value
+			case inMachine && tokens[i] == "password":
+				password = value
+			case inDefault && tokens[i] == "login":
+				defaultLogin = value
+			case inDefault && tokens[i] == "password":
+				defaultPassword = value
+			}
+			i++
+		}
+	}
+
+	if matched {
+		return login, password, true, nil
+	}
+	if hasDefault {
+		return defaultLogin, defaultPassword, true, nil
+	}
+	return "", "", false, nil
+}
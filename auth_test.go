@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestOptionsMissingNetrc(t *testing.T) {
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+
+	options, err := Source{URL: "https://example.com/list.txt", Netrc: true}.RequestOptions()
+	if err != nil {
+		t.Fatalf("a missing .netrc must not fail the source: %v", err)
+	}
+	if options.HasAuth {
+		t.Errorf("expected no credentials, got '%s'", options.Username)
+	}
+}
+
+func TestRedirectDropsHeadersForOtherHost(t *testing.T) {
+	// Заголовки, дошедшие до сервера, на который перенаправили запрос
+	received := make(chan http.Header, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		fmt.Fprintln(w, "example.com")
+	}))
+	defer target.Close()
+
+	// Тот же адрес, но под другим именем хоста
+	targetURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1) + "/list.txt"
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, targetURL, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	retries := 0
+	source := Source{
+		URL:         origin.URL + "/list.txt",
+		Category:    "test",
+		ContentType: DefaultList,
+		Headers:     map[string]string{"Private-Token": "secret"},
+		UserAgent:   "lists-bot",
+		Proxy:       &ProxyOption{URL: proxyDirect},
+		Retries:     &retries,
+	}
+	checkDownloaded(t, processSource(source, newTestFetcher(t, nil)))
+
+	header := <-received
+	if token := header.Get("Private-Token"); token != "" {
+		t.Errorf("the source header was sent to another host: '%s'", token)
+	}
+	if userAgent := header.Get("User-Agent"); userAgent != "lists-bot" {
+		t.Errorf("expected the User-Agent 'lists-bot', got '%s'", userAgent)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// Типы и подтипы записей MRT (RFC 6396, RFC 8050)
+const (
+	mrtTableDumpV2 = 13
+
+	mrtRibIPv4Unicast        = 2
+	mrtRibIPv6Unicast        = 4
+	mrtRibIPv4UnicastAddPath = 8
+	mrtRibIPv6UnicastAddPath = 10
+)
+
+// Атрибут AS_PATH и типы его сегментов (RFC 4271)
+const (
+	bgpAttrASPath    = 2
+	bgpAttrExtLength = 0x10 // Флаг атрибута: длина занимает два байта
+
+	bgpASSet      = 1
+	bgpASSequence = 2
+)
+
+// maxMRTRecordSize ограничивает размер одной записи MRT
+const maxMRTRecordSize = 1 << 24
+
+// bgpOriginFilter выбирает сети по автономной системе, которая их анонсирует (последняя AS в AS_PATH)
+type bgpOriginFilter map[uint32]bool
+
+// parseBgpTable извлекает сети, анонсированные автономными системами из поля asns источника, из локального дампа
+// таблицы BGP: файла MRT TABLE_DUMP_V2 (RIB от RouteViews, RIPE RIS) или table.jsonl от bgp.tools.
+// Формат определяется по содержимому. Пересекающиеся и соседние сети объединяются
+func parseBgpTable(input io.Reader, source Source, emit func(Entry)) error {
+	if len(source.ASNs) == 0 {
+		return fmt.Errorf("'asns' is required to select prefixes from a BGP table")
+	}
+	origins := bgpOriginFilter{}
+	for _, asn := range source.ASNs {
+		origins[uint32(asn)] = true
+	}
+
+	var builder netipx.IPSetBuilder
+	add := func(prefix netip.Prefix) {
+		builder.AddPrefix(prefix.Masked())
+	}
+
+	buffered := bufio.NewReader(input)
+	first, err := buffered.Peek(1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	var invalid int
+	if len(first) > 0 && (first[0] == '{' || first[0] == ' ' || first[0] == '\n') {
+		invalid, err = readBgpToolsTable(buffered, origins, add)
+	} else {
+		invalid, err = readMRTTable(buffered, origins, add)
+	}
+	if err != nil {
+		return err
+	}
+	if invalid > 0 {
+		logWarn.Printf("source '%s': skipped %d invalid records", source.URL, invalid)
+	}
+
+	set, err := builder.IPSet()
+	if err != nil {
+		return err
+	}
+	prefixes := set.Prefixes()
+	logInfo.Printf("source '%s': %d prefixes after aggregation", source.URL, len(prefixes))
+	for _, prefix := range prefixes {
+		emit(Entry{Value: prefix.String(), IsIP: true})
+	}
+	return nil
+}
+
+// bgpToolsRoute строка table.jsonl от bgp.tools (Например: {"CIDR":"1.1.1.0/24","ASN":13335,"Hits":1500})
+type bgpToolsRoute struct {
+	CIDR string `json:"CIDR"`
+	ASN  uint32 `json:"ASN"`
+}
+
+// readBgpToolsTable разбирает table.jsonl от bgp.tools. Возвращает количество пропущенных некорректных строк
+func readBgpToolsTable(input io.Reader, origins bgpOriginFilter, add func(netip.Prefix)) (int, error) {
+	invalid := 0
+	err := scanLines(input, func(line string) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return
+		}
+		var route bgpToolsRoute
+		if err := json.Unmarshal([]byte(line), &route); err != nil {
+			invalid++
+			return
+		}
+		if !origins[route.ASN] {
+			return
+		}
+		prefix, err := netip.ParsePrefix(route.CIDR)
+		if err != nil {
+			invalid++
+			return
+		}
+		add(prefix)
+	})
+	return invalid, err
+}
+
+// readMRTTable потоково разбирает файл MRT и передаёт в add сети из записей RIB TABLE_DUMP_V2, хотя бы один маршрут
+// которых анонсирован выбранной автономной системой. Остальные типы записей пропускаются
+func readMRTTable(input io.Reader, origins bgpOriginFilter, add func(netip.Prefix)) (int, error) {
+	header := make([]byte, 12)
+	invalid, ribs := 0, 0
+	for {
+		// Заголовок: timestamp (4), type (2), subtype (2), length (4)
+		if _, err := io.ReadFull(input, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return invalid, fmt.Errorf("invalid MRT file: %v", err)
+		}
+		recordType := binary.BigEndian.Uint16(header[4:6])
+		subtype := binary.BigEndian.Uint16(header[6:8])
+		length := binary.BigEndian.Uint32(header[8:12])
+		if length > maxMRTRecordSize {
+			return invalid, fmt.Errorf("invalid MRT file: record of %d bytes", length)
+		}
+
+		if recordType != mrtTableDumpV2 {
+			if _, err := io.CopyN(io.Discard, input, int64(length)); err != nil {
+				return invalid, fmt.Errorf("invalid MRT file: %v", err)
+			}
+			continue
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(input, record); err != nil {
+			return invalid, fmt.Errorf("invalid MRT file: %v", err)
+		}
+
+		var ipv6, addPath bool
+		switch subtype {
+		case mrtRibIPv4Unicast:
+		case mrtRibIPv6Unicast:
+			ipv6 = true
+		case mrtRibIPv4UnicastAddPath:
+			addPath = true
+		case mrtRibIPv6UnicastAddPath:
+			ipv6, addPath = true, true
+		default:
+			// PEER_INDEX_TABLE, multicast и прочие подтипы сетей не содержат
+			continue
+		}
+
+		ribs++
+		prefix, matched, err := parseMRTRib(record, ipv6, addPath, origins)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if matched {
+			add(prefix)
+		}
+	}
+
+	if ribs == 0 {
+		return invalid, fmt.Errorf("no TABLE_DUMP_V2 RIB records found, the file is not an MRT RIB dump")
+	}
+	return invalid, nil
+}
+
+// parseMRTRib разбирает запись RIB: sequence (4), prefix length (1), prefix, entry count (2) и маршруты
+// от разных пиров: peer index (2), originated time (4), [path id (4)], attribute length (2), атрибуты BGP
+func parseMRTRib(record []byte, ipv6, addPath bool, origins bgpOriginFilter) (netip.Prefix, bool, error) {
+	if len(record) < 5 {
+		return netip.Prefix{}, false, fmt.Errorf("truncated RIB record")
+	}
+	bits := int(record[4])
+	size := (bits + 7) / 8
+	addrLen := 4
+	if ipv6 {
+		addrLen = 16
+	}
+	if bits > addrLen*8 || len(record) < 5+size+2 {
+		return netip.Prefix{}, false, fmt.Errorf("invalid RIB prefix")
+	}
+	addrBytes := make([]byte, addrLen)
+	copy(addrBytes, record[5:5+size])
+	addr, _ := netip.AddrFromSlice(addrBytes)
+	prefix := netip.PrefixFrom(addr, bits)
+
+	data := record[5+size:]
+	count := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	headerSize := 8
+	if addPath {
+		headerSize = 12
+	}
+	for i := 0; i < count; i++ {
+		if len(data) < headerSize {
+			return prefix, false, fmt.Errorf("truncated RIB entry")
+		}
+		attrLen := int(binary.BigEndian.Uint16(data[headerSize-2 : headerSize]))
+		if len(data) < headerSize+attrLen {
+			return prefix, false, fmt.Errorf("truncated RIB entry attributes")
+		}
+		matched, err := bgpOriginMatches(data[headerSize:headerSize+attrLen], origins)
+		if err != nil {
+			return prefix, false, err
+		}
+		if matched {
+			return prefix, true, nil
+		}
+		data = data[headerSize+attrLen:]
+	}
+	return prefix, false, nil
+}
+
+// bgpOriginMatches ищет в атрибутах маршрута AS_PATH и проверяет автономную систему, анонсирующую сеть.
+// В TABLE_DUMP_V2 номера AS всегда занимают 4 байта. Если путь заканчивается AS_SET (агрегированный маршрут),
+// подходит любая AS из набора
+func bgpOriginMatches(attributes []byte, origins bgpOriginFilter) (bool, error) {
+	for len(attributes) > 0 {
+		if len(attributes) < 3 {
+			return false, fmt.Errorf("truncated BGP attribute")
+		}
+		flags, attrType := attributes[0], attributes[1]
+		var length, offset int
+		if flags&bgpAttrExtLength != 0 {
+			if len(attributes) < 4 {
+				return false, fmt.Errorf("truncated BGP attribute")
+			}
+			length, offset = int(binary.BigEndian.Uint16(attributes[2:4])), 4
+		} else {
+			length, offset = int(attributes[2]), 3
+		}
+		if len(attributes) < offset+length {
+			return false, fmt.Errorf("truncated BGP attribute")
+		}
+		value := attributes[offset : offset+length]
+		attributes = attributes[offset+length:]
+		if attrType != bgpAttrASPath {
+			continue
+		}
+
+		// Сегменты: type (1), count (1), AS (4 * count). Нужен последний сегмент
+		var last []byte
+		var lastType byte
+		for len(value) > 0 {
+			if len(value) < 2 || len(value) < 2+int(value[1])*4 {
+				return false, fmt.Errorf("truncated AS_PATH")
+			}
+			segmentType, size := value[0], 2+int(value[1])*4
+			if value[1] > 0 {
+				last, lastType = value[2:size], segmentType
+			}
+			value = value[size:]
+		}
+		switch {
+		case len(last) == 0:
+			// Пустой AS_PATH - сеть анонсирована самим пиром, чей номер в записи не указан
+			return false, nil
+		case lastType == bgpASSequence:
+			return origins[binary.BigEndian.Uint32(last[len(last)-4:])], nil
+		case lastType == bgpASSet:
+			for i := 0; i < len(last); i += 4 {
+				if origins[binary.BigEndian.Uint32(last[i:i+4])] {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// testMRTRoute маршрут записи RIB: сегменты AS_PATH (тип и номера AS)
+type testMRTRoute [][]uint32
+
+// appendMRTRecord добавляет к файлу MRT запись с заголовком
+func appendMRTRecord(data []byte, recordType, subtype uint16, record []byte) []byte {
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = binary.BigEndian.AppendUint16(data, recordType)
+	data = binary.BigEndian.AppendUint16(data, subtype)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(record)))
+	return append(data, record...)
+}
+
+// newTestMRTRib собирает запись RIB TABLE_DUMP_V2 с маршрутами от разных пиров. Первое число сегмента - его тип
+func newTestMRTRib(prefix string, routes ...testMRTRoute) (uint16, []byte) {
+	parsed := netip.MustParsePrefix(prefix)
+	subtype := uint16(mrtRibIPv4Unicast)
+	if parsed.Addr().Is6() {
+		subtype = mrtRibIPv6Unicast
+	}
+
+	record := binary.BigEndian.AppendUint32(nil, 0)
+	record = append(record, byte(parsed.Bits()))
+	record = append(record, parsed.Addr().AsSlice()[:(parsed.Bits()+7)/8]...)
+	record = binary.BigEndian.AppendUint16(record, uint16(len(routes)))
+	for _, route := range routes {
+		var path []byte
+		for _, segment := range route {
+			path = append(path, byte(segment[0]), byte(len(segment)-1))
+			for _, asn := range segment[1:] {
+				path = binary.BigEndian.AppendUint32(path, asn)
+			}
+		}
+		// Атрибут ORIGIN перед AS_PATH проверяет пропуск остальных атрибутов
+		attributes := []byte{0x40, 1, 1, 0, 0x40, bgpAttrASPath, byte(len(path))}
+		attributes = append(attributes, path...)
+
+		record = binary.BigEndian.AppendUint16(record, 0)
+		record = binary.BigEndian.AppendUint32(record, 0)
+		record = binary.BigEndian.AppendUint16(record, uint16(len(attributes)))
+		record = append(record, attributes...)
+	}
+	return subtype, record
+}
+
+func TestParseBgpTableMRT(t *testing.T) {
+	// PEER_INDEX_TABLE и записи другого типа пропускаются
+	data := appendMRTRecord(nil, mrtTableDumpV2, 1, []byte{1, 2, 3, 4})
+	data = appendMRTRecord(data, 16, 4, []byte{0, 0})
+	for _, rib := range []struct {
+		prefix string
+		routes []testMRTRoute
+	}{
+		// Две соседние /24 объединяются в /23
+		{"1.0.0.0/24", []testMRTRoute{{{bgpASSequence, 174, 13335}}}},
+		{"1.0.1.0/24", []testMRTRoute{{{bgpASSequence, 3356, 13335}}}},
+		// /25 внутри уже выбранной /23 поглощается
+		{"1.0.0.0/25", []testMRTRoute{{{bgpASSequence, 13335}}}},
+		// Сеть другой AS, в пути которой есть выбранная AS
+		{"1.0.2.0/24", []testMRTRoute{{{bgpASSequence, 13335, 174}}}},
+		// Подходит второй маршрут
+		{"2.0.0.0/16", []testMRTRoute{{{bgpASSequence, 174}}, {{bgpASSequence, 3356, 24940}}}},
+		// Агрегированный маршрут с AS_SET в конце пути
+		{"3.0.0.0/8", []testMRTRoute{{{bgpASSequence, 174}, {bgpASSet, 1, 24940}}}},
+		{"2a00::/16", []testMRTRoute{{{bgpASSequence, 174, 13335}}}},
+	} {
+		subtype, record := newTestMRTRib(rib.prefix, rib.routes...)
+		data = appendMRTRecord(data, mrtTableDumpV2, subtype, record)
+	}
+	// Обрезанная запись RIB пропускается
+	data = appendMRTRecord(data, mrtTableDumpV2, mrtRibIPv4Unicast, []byte{0, 0, 0, 0, 24})
+
+	var entries []string
+	source := Source{URL: "test", ASNs: []ASN{13335, 24940}}
+	err := parseBgpTable(bytes.NewReader(data), source, func(entry Entry) {
+		entries = append(entries, entry.Value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"1.0.0.0/23", "2.0.0.0/16", "3.0.0.0/8", "2a00::/16"}
+	if fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, entries)
+	}
+}
+
+func TestParseBgpTableNotMRT(t *testing.T) {
+	data := appendMRTRecord(nil, 16, 4, []byte{0, 0})
+	err := parseBgpTable(bytes.NewReader(data), Source{URL: "test", ASNs: []ASN{13335}}, func(Entry) {})
+	if err == nil {
+		t.Error("expected an error for a file without RIB records")
+	}
+}
+
+func TestParseBgpTableJSONL(t *testing.T) {
+	const table = `{"CIDR":"1.1.1.0/24","ASN":13335,"Hits":1500}
+{"CIDR":"1.0.0.0/24","ASN":13335,"Hits":900}
+{"CIDR":"1.0.0.0/25","ASN":13335,"Hits":10}
+{"CIDR":"8.8.8.0/24","ASN":15169,"Hits":1000}
+{"CIDR":"2606:4700::/32","ASN":13335,"Hits":800}
+not json
+`
+	var entries []string
+	err := parseBgpTable(strings.NewReader(table), Source{URL: "test", ASNs: []ASN{13335}}, func(entry Entry) {
+		entries = append(entries, entry.Value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"1.0.0.0/24", "1.1.1.0/24", "2606:4700::/32"}
+	if fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, entries)
+	}
+}
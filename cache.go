@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// cacheMeta метаданные закешированного ответа, необходимые для условных запросов
+type cacheMeta struct {
+	URL          string `json:"url"`          // Ссылка, по которой был получен ответ
+	ETag         string `json:"etag"`         // Значение заголовка ETag
+	LastModified string `json:"lastModified"` // Значение заголовка Last-Modified
+	Encoding     string `json:"encoding"`     // Значение заголовка Content-Encoding
+}
+
+// downloadCache хранит тела ответов и их заголовки в директории dir
+type downloadCache struct {
+	dir string
+}
+
+// newDownloadCache создаёт кеш в директории dir; пустой dir означает, что кеш отключен
+func newDownloadCache(dir string) (*downloadCache, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory '%s': %v", dir, err)
+	}
+	return &downloadCache{dir: dir}, nil
+}
+
+// paths возвращает пути к файлу с телом ответа и к файлу с метаданными для ссылки rawURL
+func (c *downloadCache) paths(rawURL string) (string, string) {
+	sum := sha256.Sum256([]byte(rawURL))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name+".body"), filepath.Join(c.dir, name+".json")
+}
+
+// load возвращает закешированные метаданные и путь к файлу с телом ответа, если они есть
+func (c *downloadCache) load(rawURL string) (*cacheMeta, string) {
+	if c == nil {
+		return nil, ""
+	}
+	bodyPath, metaPath := c.paths(rawURL)
+
+	metaData, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, ""
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		logWarn.Printf("cache entry '%s' is corrupted and will be ignored: %v", metaPath, err)
+		return nil, ""
+	}
+
+	if _, err := os.Stat(bodyPath); err != nil {
+		return nil, ""
+	}
+	return &meta, bodyPath
+}
+
+// createTemp создаёт временный файл для скачивания тела ответа. Файл создаётся в директории кеша,
+// чтобы потом его можно было переместить в кеш без копирования
+func (c *downloadCache) createTemp() (*os.File, error) {
+	if c == nil {
+		return os.CreateTemp("", "generate-geoip-geosite-*.tmp")
+	}
+	return os.CreateTemp(c.dir, "download-*.tmp")
+}
+
+// store перемещает скачанное тело ответа tmpPath в кеш, сохраняет его заголовки ETag/Last-Modified
+// и возвращает путь к телу в кеше
+func (c *downloadCache) store(rawURL string, header http.Header, tmpPath string) (string, error) {
+	bodyPath, metaPath := c.paths(rawURL)
+
+	metaData, err := json.MarshalIndent(cacheMeta{
+		URL:          rawURL,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Encoding:     header.Get("Content-Encoding"),
+	}, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	// Сначала перемещаем тело, затем пишем метаданные, чтобы метаданные никогда не ссылались на неполное тело
+	if err := os.Rename(tmpPath, bodyPath); err != nil {
+		return "", err
+	}
+	return bodyPath, writeFileAtomic(metaPath, metaData)
+}
+
+// applyConditionalHeaders добавляет в запрос заголовки If-None-Match/If-Modified-Since
+func (meta *cacheMeta) applyConditionalHeaders(req *http.Request) {
+	if meta == nil {
+		return
+	}
+	if meta.ETag != "" {
+		req.Header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		req.Header.Set("If-Modified-Since", meta.LastModified)
+	}
+}
+
+// writeFileAtomic записывает файл через временный файл, чтобы прерванная запись не оставила его повреждённым
+func writeFileAtomic(fileName string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
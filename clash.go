@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Поведение (behavior) rule-provider'а Clash / Mihomo
+const (
+	clashBehaviorDomain    = "domain"    // Каждая строка - домен или шаблон домена (+.example.com, .example.com, *.example.com)
+	clashBehaviorIPCIDR    = "ipcidr"    // Каждая строка - IP-адрес или сеть
+	clashBehaviorClassical = "classical" // Каждая строка - правило вида ТИП,значение[,параметры]
+)
+
+// parseClashRuleProvider разбирает rule-provider Clash / Mihomo: YAML со списком payload: или текстовый файл
+// с одной записью в строке. Поведение задаётся полем behavior источника, по умолчанию оно определяется
+// для каждой строки. Правила, которые нельзя перенести в списки, пропускаются, их количество по типам выводится в лог
+func parseClashRuleProvider(input io.Reader, source Source, emit func(Entry)) error {
+	behavior := strings.ToLower(source.Behavior)
+	switch behavior {
+	case "", clashBehaviorDomain, clashBehaviorIPCIDR, clashBehaviorClassical:
+	default:
+		return fmt.Errorf("unknown rule-provider behavior '%s', expected 'domain', 'ipcidr' or 'classical'", source.Behavior)
+	}
+
+	unsupported := map[string]int{}
+	err := scanLines(input, func(line string) {
+		value := clashPayloadItem(line)
+		if value == "" {
+			return
+		}
+
+		// Определяем поведение строки, если оно не задано
+		lineBehavior := behavior
+		if lineBehavior == "" {
+			switch {
+			case rgxClashRule.MatchString(value):
+				lineBehavior = clashBehaviorClassical
+			case isIPOrNetwork(value):
+				lineBehavior = clashBehaviorIPCIDR
+			default:
+				lineBehavior = clashBehaviorDomain
+			}
+		}
+
+		switch lineBehavior {
+		case clashBehaviorDomain:
+			if entry, ok := clashDomainEntry(value); ok {
+				emit(entry)
+			} else {
+				unsupported["invalid domain"]++
+			}
+		case clashBehaviorIPCIDR:
+			if isIPOrNetwork(value) {
+				emit(Entry{Value: value, IsIP: true})
+			} else {
+				unsupported["invalid IP-CIDR"]++
+			}
+		case clashBehaviorClassical:
+			if entry, ruleType, ok := clashClassicalEntry(value); ok {
+				emit(entry)
+			} else {
+				unsupported[ruleType]++
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Сообщаем, какие правила не удалось перенести
+	if len(unsupported) > 0 {
+		var ruleTypes []string
+		total := 0
+		for ruleType, count := range unsupported {
+			ruleTypes = append(ruleTypes, fmt.Sprintf("%s (%d)", ruleType, count))
+			total += count
+		}
+		sort.Strings(ruleTypes)
+		logWarn.Printf("source '%s': skipped %d rules that cannot be represented: %s", source.URL, total, strings.Join(ruleTypes, ", "))
+	}
+	return nil
+}
+
+// rgxClashRule находит правила classical вида ТИП,значение (Например: DOMAIN-SUFFIX,example.com)
+var rgxClashRule = regexp.MustCompile(`^[A-Z][A-Z0-9-]*,`)
+
+// clashPayloadItem возвращает значение из строки rule-provider'а: элемент списка payload: в YAML
+// ("  - '+.example.com'") или строку текстового формата. Для комментариев и служебных строк возвращает ""
+func clashPayloadItem(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "payload:") {
+		return ""
+	}
+
+	// Элемент YAML-списка
+	if strings.HasPrefix(line, "- ") || line == "-" {
+		line = strings.TrimSpace(line[1:])
+	}
+
+	// Значение в кавычках берём как есть, у значения без кавычек отрезаем комментарий
+	if len(line) >= 2 && (line[0] == '\'' || line[0] == '"') {
+		if end := strings.IndexByte(line[1:], line[0]); end >= 0 {
+			return strings.TrimSpace(line[1 : end+1])
+		}
+	}
+	if i := strings.Index(line, " #"); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// isIPOrNetwork проверяет, является ли строка IP-адресом или сетью
+func isIPOrNetwork(value string) bool {
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return true
+	}
+	return net.ParseIP(value) != nil
+}
+
+// clashDomainEntry переводит домен поведения domain в запись списка:
+// "+.example.com" - домен и поддомены, ".example.com" - только поддомены,
+// "*.example.com" - поддомены одного уровня, остальные "*" - любая часть домена без точки
+func clashDomainEntry(value string) (Entry, bool) {
+	value = strings.ToLower(value)
+	if strings.HasPrefix(value, "+.") {
+		domain := value[2:]
+		if !rgxDomain.MatchString(domain) || strings.Contains(domain, "*") {
+			return Entry{}, false
+		}
+		return Entry{Value: "*." + domain}, true
+	}
+
+	if strings.HasPrefix(value, ".") || strings.Contains(value, "*") {
+		// Такие шаблоны нельзя записать доменом или суффиксом, поэтому переводим их в регулярное выражение
+		pattern := value
+		prefix := "^"
+		if strings.HasPrefix(pattern, ".") {
+			prefix, pattern = "^.+\\.", pattern[1:]
+		}
+		if !rgxDomain.MatchString(pattern) {
+			return Entry{}, false
+		}
+		pattern = strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `[^.]+`)
+		return Entry{Value: "regexp:" + prefix + pattern + "$"}, true
+	}
+
+	if !rgxDomain.MatchString(value) {
+		return Entry{}, false
+	}
+	return Entry{Value: value}, true
+}
+
+// clashClassicalEntry переводит правило classical в запись списка. Возвращает тип правила,
+// чтобы можно было сообщить о неподдерживаемых типах
+func clashClassicalEntry(rule string) (Entry, string, bool) {
+	parts := strings.Split(rule, ",")
+	ruleType := strings.ToUpper(strings.TrimSpace(parts[0]))
+	if len(parts) < 2 {
+		return Entry{}, ruleType, false
+	}
+	// Параметры после значения (например, no-resolve) на список не влияют
+	value := strings.TrimSpace(parts[1])
+
+	switch ruleType {
+	case "DOMAIN":
+		value = strings.ToLower(value)
+		if rgxDomain.MatchString(value) && !strings.Contains(value, "*") {
+			return Entry{Value: value}, ruleType, true
+		}
+	case "DOMAIN-SUFFIX":
+		value = strings.ToLower(strings.TrimPrefix(value, "."))
+		if rgxDomain.MatchString(value) && !strings.Contains(value, "*") {
+			return Entry{Value: "*." + value}, ruleType, true
+		}
+	case "DOMAIN-KEYWORD":
+		if value != "" {
+			return Entry{Value: "keyword:" + strings.ToLower(value)}, ruleType, true
+		}
+	case "DOMAIN-REGEX":
+		if _, err := regexp.Compile(value); err == nil {
+			return Entry{Value: "regexp:" + value}, ruleType, true
+		}
+	case "IP-CIDR", "IP-CIDR6":
+		if isIPOrNetwork(value) {
+			return Entry{Value: value, IsIP: true}, ruleType, true
+		}
+	}
+	return Entry{}, ruleType, false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 // CmdLineOptions содержит значения параметров командной строки
@@ -14,6 +15,12 @@ type CmdLineOptions struct {
 	OutputDir  string
 	SourceFile string
 	Generate   GenerateOptions
+	Workers    int
+	PerHost    int
+	CacheDir   string
+	Download   DownloadOptions
+	FailSoft   bool
+	Proxy      string
 	ShowHelp   bool
 }
 
@@ -41,6 +48,26 @@ func ParseCommandLine() *CmdLineOptions {
 	flag.StringVar(&options.OutputDir, "o", "", "set output directory path for Geosite, GeoIP, Rule-set files (.db, rule-set.json, rule-set.srs)")
 	flag.StringVar(&options.OutputDir, "outputDir", "", "set output directory path for Geosite, GeoIP, Rule-set files (.db, rule-set.json, rule-set.srs) (shorthand)")
 
+	flag.IntVar(&options.Workers, "w", 4, "set the number of sources downloaded and parsed in parallel")
+	flag.IntVar(&options.Workers, "workers", 4, "set the number of sources downloaded and parsed in parallel (shorthand)")
+	flag.IntVar(&options.PerHost, "p", 2, "set the maximum number of parallel downloads from one host (0 = unlimited)")
+	flag.IntVar(&options.PerHost, "perHost", 2, "set the maximum number of parallel downloads from one host (0 = unlimited) (shorthand)")
+
+	flag.StringVar(&options.CacheDir, "c", "", "set download cache directory path (enables conditional requests with ETag/Last-Modified)")
+	flag.StringVar(&options.CacheDir, "cacheDir", "", "set download cache directory path (enables conditional requests with ETag/Last-Modified) (shorthand)")
+
+	flag.DurationVar(&options.Download.ConnectTimeout, "connectTimeout", 15*time.Second, "set the timeout for establishing a connection to a source")
+	flag.DurationVar(&options.Download.ReadTimeout, "readTimeout", 60*time.Second, "set the maximum time to wait for the next chunk of data from a source")
+	flag.DurationVar(&options.Download.Timeout, "timeout", 10*time.Minute, "set the total time allowed for downloading one source, including retries")
+	options.Download.MaxBodySize = 1 << 30
+	flag.Var(&options.Download.MaxBodySize, "maxBodySize", "set the maximum size of a downloaded or decompressed source file, e.g. 512MB or 2GiB (0 = unlimited)")
+	flag.IntVar(&options.Download.Retries, "retries", 3, "set the number of retries on network errors and 5xx responses")
+	flag.DurationVar(&options.Download.RetryDelay, "retryDelay", time.Second, "set the initial delay between retries (doubled on every attempt, with jitter)")
+
+	flag.BoolVar(&options.FailSoft, "fail-soft", false, "keep the previously written lists of failed sources and continue generation (sources with \"required\": true still fail the run)")
+
+	flag.StringVar(&options.Proxy, "proxy", "", "set the proxy for downloading sources: http://, https://, socks5:// URL, a sing-box outbound JSON or a path to a file with it")
+
 	flag.BoolVar(&options.Generate.GeoIP, "gen-geoip", false, "generate GeoIP files")
 	flag.BoolVar(&options.Generate.Geosite, "gen-geosite", false, "generate Geosite files")
 	flag.BoolVar(&options.Generate.RuleSetJSON, "gen-rule-set-json", false, "generate Rule-set JSON file")
@@ -79,6 +106,17 @@ func PrintHelp() {
 	fmt.Println("  -i, --inputDir string           set input directory path for listing files ({include/exclude}-{ip/domain}-{category_name}.{lst/rgx})")
 	fmt.Println("  -o, --outputDir string          set output directory path for Geosite, GeoIP, Rule-set files (.db, rule-set.json, rule-set.srs)")
 	fmt.Println("  -s, --sources string            set sources.json file path containing an array of URLs for download")
+	fmt.Println("  -w, --workers int               set the number of sources downloaded and parsed in parallel (default 4)")
+	fmt.Println("  -p, --perHost int               set the maximum number of parallel downloads from one host, 0 = unlimited (default 2)")
+	fmt.Println("  -c, --cacheDir string           set download cache directory path (enables conditional requests with ETag/Last-Modified)")
+	fmt.Println("      --connectTimeout duration   set the timeout for establishing a connection to a source (default 15s)")
+	fmt.Println("      --readTimeout duration      set the maximum time to wait for the next chunk of data from a source (default 1m0s)")
+	fmt.Println("      --timeout duration          set the total time allowed for downloading one source, including retries (default 10m0s)")
+	fmt.Println("      --maxBodySize size          set the maximum size of a downloaded or decompressed source file, e.g. 512MB, 0 = unlimited (default 1GiB)")
+	fmt.Println("      --retries int               set the number of retries on network errors and 5xx responses (default 3)")
+	fmt.Println("      --retryDelay duration       set the initial delay between retries, doubled on every attempt with jitter (default 1s)")
+	fmt.Println("      --fail-soft                 keep the previously written lists of failed sources and continue generation")
+	fmt.Println("      --proxy string              set the proxy for downloading sources: http://, https://, socks5:// URL, a sing-box outbound JSON or a path to a file with it")
 	fmt.Println("      --gen-geoip                 generate GeoIP file")
 	fmt.Println("      --gen-geosite               generate Geosite file")
 	fmt.Println("      --gen-rule-set-json         generate Rule-Set JSON files")
@@ -106,9 +144,32 @@ func ValidateOptions(options *CmdLineOptions) error {
 		return fmt.Errorf("output directory path is required")
 	}
 
+	// Количество воркеров не может быть меньше одного
+	if options.Workers < 1 {
+		return fmt.Errorf("number of workers must be at least 1")
+	}
+
+	// Ограничение на хост не может быть отрицательным
+	if options.PerHost < 0 {
+		return fmt.Errorf("perHost limit cannot be negative")
+	}
+
+	// Количество повторов не может быть отрицательным
+	if options.Download.Retries < 0 {
+		return fmt.Errorf("number of retries cannot be negative")
+	}
+
+	// Проверяем, что прокси задан корректно
+	if _, err := parseProxyOption(options.Proxy); err != nil {
+		return fmt.Errorf("invalid proxy: %v", err)
+	}
+
 	// Добавляем в конец "/", если он отсутсвует
 	options.InputDir = addTrailingSlash(options.InputDir)
 	options.OutputDir = addTrailingSlash(options.OutputDir)
+	if options.CacheDir != "" {
+		options.CacheDir = addTrailingSlash(options.CacheDir)
+	}
 
 	// Если не выбран ни один из параметров, выбираем их все
 	if !options.Generate.GeoIP && !options.Generate.Geosite && !options.Generate.RuleSetJSON && !options.Generate.RuleSetSRS {
@@ -3,7 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -12,24 +17,239 @@ type Config struct {
 	InputDir   string          // Директория, откуда будут браться списки для генерации (сюда же будут качаться файлы)
 	OutputDir  string          // Директория, куда будут складываться сгенерированный файлы
 	Generate   GenerateOptions // Массив с выбранными генерируемыми файлами
+	Workers    int             // Количество одновременно скачиваемых и обрабатываемых источников
+	PerHost    int             // Максимальное количество одновременных скачиваний с одного хоста (0 = без ограничений)
+	CacheDir   string          // Директория для кеша скачанных файлов (пусто = кеш отключен)
+	Download   DownloadOptions // Глобальные параметры скачивания (таймауты и повторы)
+	FailSoft   bool            // Не прерывать генерацию при сбое необязательных источников
+	Proxy      string          // Глобальный прокси: ссылка, "direct", JSON outbound'а sing-box или путь к нему
+}
+
+// DownloadOptions параметры скачивания: таймауты и повторные попытки
+type DownloadOptions struct {
+	ConnectTimeout time.Duration // Таймаут установки соединения (включая TLS-рукопожатие)
+	ReadTimeout    time.Duration // Максимальное время ожидания очередной порции данных от сервера
+	Timeout        time.Duration // Общее время на скачивание файла, включая все повторные попытки
+	Retries        int           // Количество повторных попыток при ошибках сети и ответах 5xx
+	RetryDelay     time.Duration // Начальная задержка перед повтором (удваивается с каждой попыткой)
+	MaxBodySize    ByteSize      // Максимальный размер скачанного и распакованного файла (0 = без ограничений)
 }
 
 // Source структура с информацией о источнике списка
 type Source struct {
-	URL         string      `json:"url"`         // Ссылка на скачиваемый файл
+	URL         string      `json:"url"`         // Ссылка на скачиваемый файл, ссылка file://, путь к файлу, директории или шаблон пути
 	Category    string      `json:"category"`    // Название категории
 	ContentType ContentType `json:"contentType"` // Как парсить файл
 	IsExclude   bool        `json:"isExclude"`   // Список с исключением или включением. false = exclude, true = include. Default: false
+	Required    bool        `json:"required"`    // Сбой этого источника прерывает генерацию даже в режиме fail-soft. Default: false
+	ArchivePath string      `json:"archivePath"` // Путь (или шаблон пути) к файлу внутри zip/tar архива, который нужно распарсить
+
+	Headers   map[string]string `json:"headers"`   // Дополнительные HTTP-заголовки (поддерживают переменные окружения: ${VAR})
+	BasicAuth *BasicAuth        `json:"basicAuth"` // Логин и пароль для HTTP Basic аутентификации
+	UserAgent string            `json:"userAgent"` // Переопределяет заголовок User-Agent
+	Netrc     bool              `json:"netrc"`     // Брать логин и пароль для хоста из файла .netrc ($NETRC или ~/.netrc)
+	Proxy     *ProxyOption      `json:"proxy"`     // Переопределяет глобальный прокси: ссылка, "direct" или outbound sing-box
+
+	SHA256    string           `json:"sha256"`    // Ожидаемый sha256 скачанного файла (hex)
+	SHA256URL string           `json:"sha256Url"` // Ссылка или путь к опубликованному .sha256 файлу
+	Minisign  *MinisignOptions `json:"minisign"`  // Проверка подписи minisign
+	Ed25519   *Ed25519Options  `json:"ed25519"`   // Проверка отсоединённой подписи ed25519
+
+	ConnectTimeout Duration `json:"connectTimeout"` // Переопределяет глобальный таймаут соединения (например: "10s")
+	ReadTimeout    Duration `json:"readTimeout"`    // Переопределяет глобальный таймаут чтения (например: "30s")
+	Timeout        Duration `json:"timeout"`        // Переопределяет глобальное общее время скачивания (например: "5m")
+	Retries        *int     `json:"retries"`        // Переопределяет глобальное количество повторных попыток
+	MaxBodySize    ByteSize `json:"maxBodySize"`    // Переопределяет глобальный максимальный размер файла (например: "512MB")
+
+	Sets       []string `json:"sets"`       // Dnsmasq: брать только домены для указанных ipset/nftset (пусто - все домены файла)
+	Attributes []string `json:"attributes"` // V2flyDomainList: брать только записи с указанными атрибутами ("cn", "!cn"; "-cn" - без атрибута)
+	Behavior   string   `json:"behavior"`   // ClashRuleProvider: поведение rule-provider'а (domain, ipcidr, classical). Default: определяется по строке
+
+	Categories map[string]string `json:"categories"` // V2rayDat, CountryIPs, RirDelegated, JsonRublacklistRegistry: коды категорий (стран) файла и категории, в которые они записываются (Например: {"geosite:category-ads-all": "ads"})
+	Countries  []string          `json:"countries"`  // CountryIPs, RirDelegated: коды стран (ISO 3166-1), сети которых объединяются в категорию источника (Например: ["RU", "BY"])
+	ASNs       []ASN             `json:"asns"`       // BgpTable: автономные системы, анонсированные которыми сети записываются в категорию (Например: [13335, "AS24940"])
+	Csv        *CsvOptions       `json:"csv"`        // Csv: разделитель, кавычки, заголовок, столбцы с IP-адресами и доменами, фильтр строк
+	Encoding   string            `json:"encoding"`   // Кодировка текстовых файлов (Например: "windows-1251", "koi8-r") или "auto". Default: UTF-8 (с учётом BOM)
+	JSON       *JsonOptions      `json:"json"`       // Json: выражения JSONPath, которые выбирают домены и IP-адреса
+
+	document string   // Разбираемый файл источника (заполняется перед парсингом)
+	fetcher  *fetcher // Скачивание файлов, на которые ссылается источник (заполняется перед парсингом)
 	// DownloadedFilename string      `json:"downloadedFilename"` // Имя временного файла для скачивания
 	// IpFilename         string      `json:"ipFilename"`         // Имя распарсенного файла с IP-адресами
 	// DomainFilename     string      `json:"domainFilename"`     // Имя распарсенного файла с Доменами
 }
 
+// OutputCategories возвращает категории, в которые пишет источник: значения categories
+// (в алфавитном порядке) и category, если categories не задан, задан countries
+// или в category попадают записи без подходящей категории (JsonRublacklistRegistry)
+func (s Source) OutputCategories() []string {
+	if len(s.Categories) == 0 {
+		return []string{s.Category}
+	}
+	seen := map[string]bool{}
+	var categories []string
+	values := make([]string, 0, len(s.Categories)+1)
+	for _, category := range s.Categories {
+		values = append(values, category)
+	}
+	if len(s.Countries) > 0 || s.ContentType == JsonRublacklistRegistry && s.Category != "" {
+		values = append(values, s.Category)
+	}
+	for _, category := range values {
+		if !seen[category] {
+			seen[category] = true
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// DownloadOptions возвращает параметры скачивания источника с учётом глобальных значений
+func (s Source) DownloadOptions(global DownloadOptions) DownloadOptions {
+	options := global
+	if s.ConnectTimeout > 0 {
+		options.ConnectTimeout = time.Duration(s.ConnectTimeout)
+	}
+	if s.ReadTimeout > 0 {
+		options.ReadTimeout = time.Duration(s.ReadTimeout)
+	}
+	if s.Timeout > 0 {
+		options.Timeout = time.Duration(s.Timeout)
+	}
+	if s.Retries != nil {
+		options.Retries = *s.Retries
+	}
+	if s.MaxBodySize > 0 {
+		options.MaxBodySize = s.MaxBodySize
+	}
+	return options
+}
+
+// Duration промежуток времени, который в JSON задаётся строкой ("30s", "1m30s") или числом секунд
+type Duration time.Duration
+
+// UnmarshalJSON разбирает Duration из строки или числа секунд
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration '%s': %v", v, err)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s, expected a string like \"30s\" or a number of seconds", string(data))
+	}
+	return nil
+}
+
+// ASN номер автономной системы. Задаётся числом или строкой ("13335", "AS13335")
+type ASN uint32
+
+// UnmarshalJSON разбирает ASN из числа или строки с необязательным префиксом "AS"
+func (a *ASN) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if value, err := strconv.Unquote(text); err == nil {
+		text = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "AS")
+	}
+	number, err := strconv.ParseUint(text, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid ASN %s, expected a number or a string like \"AS13335\"", string(data))
+	}
+	*a = ASN(number)
+	return nil
+}
+
+// ByteSize размер в байтах. Задаётся числом байт или строкой с единицей измерения ("512MB", "1GiB")
+type ByteSize int64
+
+// byteSizeUnits множители единиц измерения размера
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+}
+
+// parseByteSize разбирает размер из строки вида "1024", "512MB" или "1GiB"
+func parseByteSize(value string) (ByteSize, error) {
+	value = strings.TrimSpace(value)
+	i := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(value)
+	}
+	number, err := strconv.ParseFloat(value[:i], 64)
+	multiplier, ok := byteSizeUnits[strings.ToUpper(strings.TrimSpace(value[i:]))]
+	if err != nil || !ok || number < 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected a number of bytes or a value like \"512MB\" or \"1GiB\"", value)
+	}
+	return ByteSize(number * float64(multiplier)), nil
+}
+
+// String возвращает размер в удобочитаемом виде
+func (b ByteSize) String() string {
+	switch {
+	case b >= 1<<30 && b%(1<<30) == 0:
+		return fmt.Sprintf("%dGiB", b>>30)
+	case b >= 1<<20 && b%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", b>>20)
+	case b >= 1<<10 && b%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", b>>10)
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// Set разбирает размер из параметра командной строки (реализует flag.Value)
+func (b *ByteSize) Set(value string) error {
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// UnmarshalJSON разбирает ByteSize из числа байт или строки с единицей измерения
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*b = ByteSize(v)
+		return nil
+	case string:
+		return b.Set(v)
+	}
+	return fmt.Errorf("invalid size %s, expected a number of bytes or a string like \"512MB\"", string(data))
+}
+
 // ContentType перечисление для определения типа обработчика данных
 type ContentType string
 
-// ParserFunc функция для обработки данных
-type ParserFunc func(input string) ([]string, []string)
+// Entry запись (IP-адрес, сеть или домен), извлечённая парсером из источника
+type Entry struct {
+	Value    string // Значение записи
+	IsIP     bool   // true, если запись - IP-адрес или сеть, false - если домен
+	Exclude  bool   // true, если запись нужно записать в список исключений (exclude) категории источника
+	Category string // Категория записи, если она отличается от категории источника
+}
+
+// ParserFunc функция для обработки данных: читает input потоком и передаёт каждую
+// найденную запись в emit, не накапливая файл целиком в памяти
+type ParserFunc func(input io.Reader, source Source, emit func(Entry)) error
 
 var parsers = map[ContentType]ParserFunc{
 	DefaultList:        parseDefaultList,
@@ -38,6 +258,19 @@ var parsers = map[ContentType]ParserFunc{
 	JsonListDomains:    parseJsonListDomains,
 	JsonListIPs:        parseJsonListIPs,
 	HostsFile:          parseHostsFile,
+	AdblockFilter:      parseAdblockFilter,
+	Dnsmasq:            parseDnsmasq,
+	V2flyDomainList:    parseV2flyDomainList,
+	ClashRuleProvider:  parseClashRuleProvider,
+	SingBoxRuleSet:     parseSingBoxRuleSet,
+	V2rayDat:           parseV2rayDat,
+	CountryIPs:         parseCountryIPs,
+	RirDelegated:       parseRirDelegated,
+	BgpTable:           parseBgpTable,
+	Csv:                parseCsv,
+	Json:               parseJson,
+
+	JsonRublacklistRegistry: parseJsonRublacklistRegistry,
 }
 
 const (
@@ -47,6 +280,19 @@ const (
 	JsonListDomains    ContentType = "JsonListDomains"    // JSON файл со списком доменов (Например: ["dom1.com","dom2.com","dom3.com"])
 	JsonListIPs        ContentType = "JsonListIPs"        // JSON файл со списком IP-адресов (Например: ["1.1.1.1","2.2.2.2","3.3.3.3"])
 	HostsFile          ContentType = "HostsFile"          // Hosts файл со списком IP-адресов и доменов
+	AdblockFilter      ContentType = "AdblockFilter"      // Фильтр в синтаксисе AdBlock Plus / AdGuard (Например: ||example.com^, @@||allow.com^)
+	Dnsmasq            ContentType = "Dnsmasq"            // Конфигурация dnsmasq (Например: server=/example.com/1.1.1.1, ipset=/example.com/vpn)
+	V2flyDomainList    ContentType = "V2flyDomainList"    // Файл из v2fly/domain-list-community (Например: domain:example.com @cn, include:other)
+	ClashRuleProvider  ContentType = "ClashRuleProvider"  // Rule-provider Clash / Mihomo в YAML или текстовом формате (Например: DOMAIN-SUFFIX,example.com)
+	SingBoxRuleSet     ContentType = "SingBoxRuleSet"     // Rule-set sing-box: исходный JSON или скомпилированный .srs
+	V2rayDat           ContentType = "V2rayDat"           // geoip.dat или geosite.dat V2Ray/Xray (категории выбираются полем categories)
+	CountryIPs         ContentType = "CountryIPs"         // Сети стран из MMDB (GeoLite2, DB-IP) или CSV (страны выбираются полями countries и categories)
+	RirDelegated       ContentType = "RirDelegated"       // Статистика распределения адресов RIR (Например: ripencc|RU|ipv4|5.8.0.0|8192|20110801|allocated)
+	BgpTable           ContentType = "BgpTable"           // Дамп таблицы BGP: MRT TABLE_DUMP_V2 или table.jsonl от bgp.tools (сети выбираются полем asns)
+	Csv                ContentType = "Csv"                // CSV файл с настраиваемыми разделителем, столбцами и кодировкой (поля csv и encoding)
+	Json               ContentType = "Json"               // JSON файл, домены и IP-адреса выбираются выражениями JSONPath (поле json)
+
+	JsonRublacklistRegistry ContentType = "JsonRublacklistRegistry" // Записи реестра Роскомсвободы (Rublacklist) с распределением по категориям по коду ограничения или типу блокировки
 )
 
 func loadSourcesFromJSON(jsonFile string) ([]Source, error) {
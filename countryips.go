@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"go4.org/netipx"
+)
+
+// countrySelection выбранные страны и категории, в которые записываются их сети
+type countrySelection struct {
+	categories map[string][]string // Код страны (в верхнем регистре) -> категории
+	found      map[string]bool     // Страны, найденные в базе
+}
+
+// newCountrySelection собирает страны источника: countries пишутся в category источника,
+// categories задаёт отдельную категорию для каждой страны
+func newCountrySelection(source Source) (*countrySelection, error) {
+	selection := &countrySelection{categories: map[string][]string{}, found: map[string]bool{}}
+	for _, country := range source.Countries {
+		code := strings.ToUpper(strings.TrimSpace(country))
+		selection.categories[code] = append(selection.categories[code], source.Category)
+	}
+	for country, category := range source.Categories {
+		code := strings.ToUpper(strings.TrimSpace(country))
+		selection.categories[code] = append(selection.categories[code], category)
+	}
+	if len(selection.categories) == 0 {
+		return nil, fmt.Errorf("'countries' or 'categories' is required to select countries")
+	}
+	return selection, nil
+}
+
+// emit передаёт в emit сеть страны code во все выбранные для неё категории
+func (s *countrySelection) emit(code string, prefix netip.Prefix, emit func(Entry)) {
+	categories := s.categories[strings.ToUpper(code)]
+	if len(categories) == 0 {
+		return
+	}
+	s.found[strings.ToUpper(code)] = true
+	for _, category := range categories {
+		emit(Entry{Value: prefix.String(), IsIP: true, Category: category})
+	}
+}
+
+// missing возвращает выбранные страны, которых нет в базе
+func (s *countrySelection) missing() []string {
+	var missing []string
+	for code := range s.categories {
+		if !s.found[code] {
+			missing = append(missing, code)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// parseCountryIPs извлекает сети выбранных стран из базы стран: MMDB (GeoLite2 Country/City, DB-IP,
+// sing-geoip) или CSV с диапазонами или сетями и кодом страны. Формат определяется по содержимому
+func parseCountryIPs(input io.Reader, source Source, emit func(Entry)) error {
+	selection, err := newCountrySelection(source)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewReaderSize(input, magicSize)
+	magic, _ := buffered.Peek(magicSize)
+	if isBinaryData(magic) {
+		err = readCountryMMDB(buffered, selection, emit)
+	} else {
+		var invalid int
+		invalid, err = readCountryCSV(buffered, selection, emit)
+		if invalid > 0 {
+			logWarn.Printf("source '%s': skipped %d invalid CSV rows", source.URL, invalid)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Опечатка в коде страны не должна незаметно давать пустую категорию
+	if missing := selection.missing(); len(missing) > 0 {
+		return fmt.Errorf("countries not found in the database: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// isBinaryData проверяет, что начало файла не похоже на текст
+func isBinaryData(data []byte) bool {
+	for _, b := range data {
+		if b < 0x09 || b > 0x0d && b < 0x20 {
+			return true
+		}
+	}
+	return false
+}
+
+// mmdbCountryRecord запись базы стран MaxMind / DB-IP
+type mmdbCountryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// readCountryMMDB перебирает сети базы MMDB. MMDB читается с произвольным доступом, поэтому файл
+// загружается в память целиком (его размер ограничен maxBodySize)
+func readCountryMMDB(input io.Reader, selection *countrySelection, emit func(Entry)) error {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("invalid MMDB file: %v", err)
+	}
+	defer reader.Close()
+	logInfo.Printf("reading the MMDB database '%s' (%s)...", reader.Metadata.DatabaseType, reader.Metadata.Description["en"])
+
+	// sing-geoip (в том числе созданный этой программой) хранит вместо записи код категории
+	singGeoIP := reader.Metadata.DatabaseType == "sing-geoip"
+
+	networks := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var code string
+		var network *net.IPNet
+		if singGeoIP {
+			network, err = networks.Network(&code)
+		} else {
+			var record mmdbCountryRecord
+			network, err = networks.Network(&record)
+			// Для адресов без страны (например, anycast) берём страну регистрации
+			code = record.Country.ISOCode
+			if code == "" {
+				code = record.RegisteredCountry.ISOCode
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		prefix, ok := netipx.FromStdIPNet(network)
+		if !ok {
+			return fmt.Errorf("invalid network %s", network)
+		}
+		selection.emit(code, prefix, emit)
+	}
+	return networks.Err()
+}
+
+// Названия столбцов CSV с сетью, началом и концом диапазона и кодом страны
+var (
+	csvNetworkColumns = map[string]bool{"network": true, "cidr": true, "prefix": true}
+	csvStartColumns   = map[string]bool{"start_ip": true, "ip_start": true, "range_start": true, "ip_from": true, "start": true, "first_ip": true}
+	csvEndColumns     = map[string]bool{"end_ip": true, "ip_end": true, "range_end": true, "ip_to": true, "end": true, "last_ip": true}
+	csvCountryColumns = map[string]bool{"country": true, "country_code": true, "country_iso_code": true, "cc": true, "iso_code": true}
+)
+
+// countryCSVColumns номера столбцов CSV (-1 - столбца нет)
+type countryCSVColumns struct {
+	network, start, end, country int
+}
+
+// readCountryCSV разбирает CSV базы стран. Столбцы определяются по заголовку или, если его нет, по первой строке:
+// "сеть,страна" или "начало,конец,страна" (DB-IP, IP2Location - адреса могут быть записаны числами).
+// Возвращает количество пропущенных некорректных строк
+func readCountryCSV(input io.Reader, selection *countrySelection, emit func(Entry)) (int, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+	reader.Comment = '#'
+
+	var columns *countryCSVColumns
+	invalid := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return invalid, nil
+		}
+		if err != nil {
+			return invalid, err
+		}
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+
+		if columns == nil {
+			detected, isHeader, err := detectCountryCSVColumns(record)
+			if err != nil {
+				return 0, err
+			}
+			columns = detected
+			if isHeader {
+				continue
+			}
+		}
+
+		if columns.country >= len(record) {
+			invalid++
+			continue
+		}
+		code := record[columns.country]
+
+		if columns.network >= 0 {
+			if columns.network >= len(record) {
+				invalid++
+				continue
+			}
+			prefix, err := netip.ParsePrefix(record[columns.network])
+			if err != nil {
+				invalid++
+				continue
+			}
+			selection.emit(code, prefix.Masked(), emit)
+			continue
+		}
+
+		if columns.start >= len(record) || columns.end >= len(record) {
+			invalid++
+			continue
+		}
+		start, ok := parseCSVAddress(record[columns.start])
+		end, ok2 := parseCSVAddress(record[columns.end])
+		ipRange := netipx.IPRangeFrom(start, end)
+		if !ok || !ok2 || !ipRange.IsValid() {
+			invalid++
+			continue
+		}
+		for _, prefix := range ipRange.Prefixes() {
+			selection.emit(code, prefix, emit)
+		}
+	}
+}
+
+// detectCountryCSVColumns определяет столбцы CSV по заголовку или по первой строке с данными.
+// Возвращает true, если строка - заголовок
+func detectCountryCSVColumns(record []string) (*countryCSVColumns, bool, error) {
+	columns := &countryCSVColumns{network: -1, start: -1, end: -1, country: -1}
+
+	// Строка без заголовка: сеть и страна или начало, конец и страна
+	if len(record) >= 2 {
+		if _, err := netip.ParsePrefix(record[0]); err == nil {
+			columns.network, columns.country = 0, 1
+			return columns, false, nil
+		}
+	}
+	if _, ok := parseCSVAddress(record[0]); ok {
+		if len(record) < 3 {
+			return nil, false, fmt.Errorf("unsupported CSV format: expected 'start,end,country' rows")
+		}
+		columns.start, columns.end, columns.country = 0, 1, 2
+		return columns, false, nil
+	}
+
+	geonames := false
+	for i, name := range record {
+		name = strings.ToLower(strings.TrimPrefix(name, "\ufeff"))
+		switch {
+		case csvNetworkColumns[name] && columns.network < 0:
+			columns.network = i
+		case csvStartColumns[name] && columns.start < 0:
+			columns.start = i
+		case csvEndColumns[name] && columns.end < 0:
+			columns.end = i
+		case csvCountryColumns[name] && columns.country < 0:
+			columns.country = i
+		case name == "geoname_id":
+			geonames = true
+		}
+	}
+
+	if columns.country < 0 {
+		if geonames {
+			// В CSV GeoLite2 страны указаны через geoname_id из отдельного файла Locations
+			return nil, false, fmt.Errorf("GeoLite2 CSV blocks reference countries by geoname_id, use the GeoLite2 Country MMDB instead")
+		}
+		return nil, false, fmt.Errorf("unsupported CSV format: no country column in the header")
+	}
+	if columns.network < 0 && (columns.start < 0 || columns.end < 0) {
+		return nil, false, fmt.Errorf("unsupported CSV format: no network or start and end columns in the header")
+	}
+	return columns, true, nil
+}
+
+// maxIPv4Number наибольший IPv4-адрес, записанный числом
+var maxIPv4Number = big.NewInt(1<<32 - 1)
+
+// parseCSVAddress разбирает IP-адрес, записанный обычным образом или числом (IP2Location)
+func parseCSVAddress(value string) (netip.Addr, bool) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), true
+	}
+
+	number, ok := new(big.Int).SetString(value, 10)
+	if !ok || number.Sign() < 0 || number.BitLen() > 128 {
+		return netip.Addr{}, false
+	}
+	if number.Cmp(maxIPv4Number) <= 0 {
+		var bytes [4]byte
+		number.FillBytes(bytes[:])
+		return netip.AddrFrom4(bytes), true
+	}
+	var bytes [16]byte
+	number.FillBytes(bytes[:])
+	return netip.AddrFrom16(bytes).Unmap(), true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestParseCountryIPsCSV(t *testing.T) {
+	tests := []struct {
+		name     string
+		csv      string
+		expected []string
+	}{
+		{
+			name:     "header with networks",
+			csv:      "network,country_iso_code\n1.0.0.0/24,RU\n1.0.1.0/24,DE\n2001:db8::/32,ru\nbad,RU\n",
+			expected: []string{"ru:1.0.0.0/24", "ru:2001:db8::/32"},
+		},
+		{
+			name:     "header with ranges",
+			csv:      "ip_start,ip_end,country\n1.0.0.0,1.0.2.255,RU\n1.0.3.0,1.0.3.255,DE\n",
+			expected: []string{"ru:1.0.0.0/23", "ru:1.0.2.0/24"},
+		},
+		{
+			// IP2Location: без заголовка, адреса записаны числами, поля в кавычках
+			name:     "numeric ranges without header",
+			csv:      "\"16777216\",\"16777471\",\"RU\",\"Russia\"\n\"16777472\",\"16778239\",\"DE\",\"Germany\"\n",
+			expected: []string{"ru:1.0.0.0/24"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []string
+			source := Source{URL: "test", Category: "ru", Countries: []string{"RU"}}
+			err := parseCountryIPs(strings.NewReader(test.csv), source, func(entry Entry) {
+				entries = append(entries, entry.Category+":"+entry.Value)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, entries)
+			}
+		})
+	}
+}
+
+func TestParseCountryIPsMissingCountry(t *testing.T) {
+	source := Source{URL: "test", Category: "ru", Countries: []string{"RU", "XX"}}
+	err := parseCountryIPs(strings.NewReader("1.0.0.0/24,RU\n"), source, func(Entry) {})
+	if err == nil || !strings.Contains(err.Error(), "XX") {
+		t.Errorf("expected an error about the missing country, got %v", err)
+	}
+}
+
+// newTestCountryMMDB создаёт базу стран в формате GeoLite2 Country
+func newTestCountryMMDB(t *testing.T, countries map[string]string) []byte {
+	t.Helper()
+	writer, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: "GeoLite2-Country"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for network, code := range countries {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Код страны "-" - сеть только со страной регистрации
+		record := mmdbtype.Map{"registered_country": mmdbtype.Map{"iso_code": mmdbtype.String("RU")}}
+		if code != "-" {
+			record["country"] = mmdbtype.Map{"iso_code": mmdbtype.String(code)}
+		}
+		if err := writer.Insert(ipNet, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buffer bytes.Buffer
+	if _, err := writer.WriteTo(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestParseCountryIPsMMDB(t *testing.T) {
+	data := newTestCountryMMDB(t, map[string]string{
+		"1.0.0.0/24": "RU",
+		"1.0.1.0/24": "DE",
+		"1.0.2.0/24": "-",
+		"2a00::/16":  "RU",
+	})
+
+	var entries []string
+	source := Source{URL: "test", Categories: map[string]string{"ru": "ru", "DE": "de"}}
+	err := parseCountryIPs(bytes.NewReader(data), source, func(entry Entry) {
+		entries = append(entries, entry.Category+":"+entry.Value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"ru:1.0.0.0/24", "de:1.0.1.0/24", "ru:1.0.2.0/24", "ru:2a00::/16"}
+	if fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, entries)
+	}
+}
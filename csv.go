@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// CsvOptions параметры разбора CSV для типа Csv
+type CsvOptions struct {
+	Delimiter     string      `json:"delimiter"`     // Разделитель столбцов. Default: ","
+	Quote         *string     `json:"quote"`         // Символ кавычек ("" - кавычки не используются). Default: "\""
+	SkipRows      int         `json:"skipRows"`      // Количество строк в начале файла, которые нужно пропустить
+	Header        bool        `json:"header"`        // Первая строка после пропущенных - заголовок (столбцы можно указывать по названию)
+	IPColumns     []CsvColumn `json:"ipColumns"`     // Столбцы с IP-адресами и сетями
+	DomainColumns []CsvColumn `json:"domainColumns"` // Столбцы с доменами
+	Separator     string      `json:"separator"`     // Разделитель нескольких значений в одной ячейке (Например: "|")
+	Filter        *CsvFilter  `json:"filter"`        // Брать только строки, подходящие под фильтр
+}
+
+// CsvFilter фильтр строк CSV по значению столбца
+type CsvFilter struct {
+	Column CsvColumn `json:"column"` // Проверяемый столбец
+	Values []string  `json:"values"` // Строка подходит, если значение столбца совпадает с одним из значений
+	Regexp string    `json:"regexp"` // Строка подходит, если значение столбца подходит под регулярное выражение
+	Invert bool      `json:"invert"` // Брать строки, которые под фильтр не подходят
+}
+
+// CsvColumn столбец CSV: номер (с нуля) или название из заголовка
+type CsvColumn struct {
+	Index int    // Номер столбца
+	Name  string // Название столбца (если задано, номер определяется по заголовку)
+}
+
+// UnmarshalJSON разбирает CsvColumn из числа или строки
+func (c *CsvColumn) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		if v < 0 || v != float64(int(v)) {
+			return fmt.Errorf("invalid column number %s", string(data))
+		}
+		*c = CsvColumn{Index: int(v)}
+	case string:
+		if v == "" {
+			return fmt.Errorf("empty column name")
+		}
+		*c = CsvColumn{Name: v}
+	default:
+		return fmt.Errorf("invalid column %s, expected a number or a header name", string(data))
+	}
+	return nil
+}
+
+// String возвращает столбец для сообщений
+func (c CsvColumn) String() string {
+	if c.Name != "" {
+		return fmt.Sprintf("'%s'", c.Name)
+	}
+	return fmt.Sprintf("%d", c.Index)
+}
+
+// resolve определяет номер столбца по заголовку
+func (c *CsvColumn) resolve(header map[string]int) error {
+	if c.Name == "" {
+		return nil
+	}
+	index, ok := header[strings.ToLower(strings.TrimSpace(c.Name))]
+	if !ok {
+		return fmt.Errorf("column '%s' not found in the header", c.Name)
+	}
+	c.Index = index
+	return nil
+}
+
+// csvReader читает записи CSV с произвольным разделителем и символом кавычек.
+// Значение в кавычках может содержать разделитель и переводы строк, кавычка внутри него удваивается
+type csvReader struct {
+	reader    *bufio.Reader
+	delimiter string
+	quote     string
+	line      int // Номер последней прочитанной строки
+}
+
+// readLine читает строку без символов перевода строки
+func (r *csvReader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if len(line) == 0 && err != nil {
+		return "", err
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	r.line++
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// read читает следующую запись. В конце файла возвращает io.EOF
+func (r *csvReader) read() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	var field strings.Builder
+	quoted, fieldStart := false, true
+	for {
+		for i := 0; i < len(line); {
+			switch {
+			case quoted:
+				if strings.HasPrefix(line[i:], r.quote) {
+					i += len(r.quote)
+					// Удвоенная кавычка - сама кавычка
+					if strings.HasPrefix(line[i:], r.quote) {
+						field.WriteString(r.quote)
+						i += len(r.quote)
+					} else {
+						quoted = false
+					}
+					continue
+				}
+				field.WriteByte(line[i])
+				i++
+			case strings.HasPrefix(line[i:], r.delimiter):
+				fields = append(fields, field.String())
+				field.Reset()
+				fieldStart = true
+				i += len(r.delimiter)
+			case fieldStart && r.quote != "" && strings.HasPrefix(line[i:], r.quote):
+				// Кавычки учитываются только в начале значения
+				quoted, fieldStart = true, false
+				i += len(r.quote)
+			default:
+				field.WriteByte(line[i])
+				fieldStart = false
+				i++
+			}
+		}
+		if !quoted {
+			break
+		}
+		// Значение в кавычках продолжается на следующей строке
+		startLine := r.line
+		if line, err = r.readLine(); err != nil {
+			return nil, fmt.Errorf("line %d: unterminated quoted value", startLine)
+		}
+		field.WriteByte('\n')
+	}
+	return append(fields, field.String()), nil
+}
+
+// csvStats счётчики пропущенных значений CSV
+type csvStats struct {
+	invalidIPs     int // Значения в столбцах IP-адресов, которые не являются IP-адресом или сетью
+	invalidDomains int // Значения в столбцах доменов, которые не являются доменом
+	shortRows      int // Строки, в которых нет нужного столбца
+}
+
+// parseCsv разбирает CSV с настраиваемыми разделителем, кавычками и столбцами (поле csv источника).
+// Каждое значение столбцов ipColumns проверяется как IP-адрес или сеть, domainColumns - как домен
+func parseCsv(input io.Reader, source Source, emit func(Entry)) error {
+	if source.Csv == nil || len(source.Csv.IPColumns) == 0 && len(source.Csv.DomainColumns) == 0 {
+		return fmt.Errorf("'csv' with 'ipColumns' or 'domainColumns' is required for the Csv content type")
+	}
+	options := *source.Csv
+	// Столбцы копируем, чтобы определение номеров по заголовку не меняло настройки источника
+	options.IPColumns = append([]CsvColumn(nil), options.IPColumns...)
+	options.DomainColumns = append([]CsvColumn(nil), options.DomainColumns...)
+
+	if options.Delimiter == "" {
+		options.Delimiter = ","
+	}
+	quote := `"`
+	if options.Quote != nil {
+		quote = *options.Quote
+	}
+	if quote != "" && strings.Contains(options.Delimiter, quote) {
+		return fmt.Errorf("the CSV delimiter and quote must differ")
+	}
+
+	var filterRegexp *regexp.Regexp
+	var filterValues map[string]bool
+	if options.Filter != nil {
+		filter := *options.Filter
+		options.Filter = &filter
+		if filter.Regexp != "" {
+			var err error
+			if filterRegexp, err = regexp.Compile(filter.Regexp); err != nil {
+				return fmt.Errorf("invalid CSV filter regexp: %v", err)
+			}
+		}
+		filterValues = map[string]bool{}
+		for _, value := range filter.Values {
+			filterValues[value] = true
+		}
+		if filterRegexp == nil && len(filterValues) == 0 {
+			return fmt.Errorf("the CSV filter needs 'values' or 'regexp'")
+		}
+	}
+
+	reader := &csvReader{reader: bufio.NewReaderSize(input, 64*1024), delimiter: options.Delimiter, quote: quote}
+
+	// Пропускаем служебные строки и читаем заголовок
+	for i := 0; i < options.SkipRows; i++ {
+		if _, err := reader.readLine(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err := resolveCsvColumns(reader, &options); err != nil {
+		return err
+	}
+
+	var stats csvStats
+	for {
+		record, err := reader.read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+
+		if options.Filter != nil {
+			if options.Filter.Column.Index >= len(record) {
+				stats.shortRows++
+				continue
+			}
+			value := strings.TrimSpace(record[options.Filter.Column.Index])
+			matched := filterValues[value] || filterRegexp != nil && filterRegexp.MatchString(value)
+			if matched == options.Filter.Invert {
+				continue
+			}
+		}
+
+		short := false
+		for _, column := range options.IPColumns {
+			if column.Index >= len(record) {
+				short = true
+				continue
+			}
+			for _, value := range splitCsvCell(record[column.Index], options.Separator) {
+				if !isIPOrNetwork(value) {
+					stats.invalidIPs++
+					continue
+				}
+				emit(Entry{Value: value, IsIP: true})
+			}
+		}
+		for _, column := range options.DomainColumns {
+			if column.Index >= len(record) {
+				short = true
+				continue
+			}
+			for _, value := range splitCsvCell(record[column.Index], options.Separator) {
+				value = strings.TrimSuffix(strings.ToLower(value), ".")
+				if !rgxDomain.MatchString(value) {
+					stats.invalidDomains++
+					continue
+				}
+				emit(Entry{Value: value})
+			}
+		}
+		if short {
+			stats.shortRows++
+		}
+	}
+
+	if stats.invalidIPs > 0 || stats.invalidDomains > 0 || stats.shortRows > 0 {
+		logWarn.Printf("source '%s': skipped %d invalid IP addresses, %d invalid domains and %d rows without the configured columns", source.URL, stats.invalidIPs, stats.invalidDomains, stats.shortRows)
+	}
+	return nil
+}
+
+// resolveCsvColumns читает заголовок (если он есть) и определяет номера столбцов, указанных по названию
+func resolveCsvColumns(reader *csvReader, options *CsvOptions) error {
+	columns := make([]*CsvColumn, 0, len(options.IPColumns)+len(options.DomainColumns)+1)
+	for i := range options.IPColumns {
+		columns = append(columns, &options.IPColumns[i])
+	}
+	for i := range options.DomainColumns {
+		columns = append(columns, &options.DomainColumns[i])
+	}
+	if options.Filter != nil {
+		columns = append(columns, &options.Filter.Column)
+	}
+
+	header := map[string]int{}
+	if options.Header {
+		record, err := reader.read()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		for i, name := range record {
+			name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+			if _, exists := header[name]; !exists {
+				header[name] = i
+			}
+		}
+	}
+
+	for _, column := range columns {
+		if column.Name != "" && !options.Header {
+			return fmt.Errorf("column %s is set by name, but 'header' is not enabled", column)
+		}
+		if err := column.resolve(header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitCsvCell разбивает ячейку на значения по separator и убирает пустые значения
+func splitCsvCell(cell, separator string) []string {
+	values := []string{cell}
+	if separator != "" {
+		values = strings.Split(cell, separator)
+	}
+	result := values[:0]
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
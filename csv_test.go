@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCsvReader(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		delimiter string
+		quote     string
+		expected  [][]string
+	}{
+		{
+			name:      "quoted fields",
+			input:     "a,\"b,c\",\"d \"\"e\"\"\"\r\n,\"\",f\n",
+			delimiter: ",",
+			quote:     `"`,
+			expected:  [][]string{{"a", "b,c", `d "e"`}, {"", "", "f"}},
+		},
+		{
+			name:      "multiline field",
+			input:     "1;'first\nsecond';3\n4;5;6",
+			delimiter: ";",
+			quote:     "'",
+			expected:  [][]string{{"1", "first\nsecond", "3"}, {"4", "5", "6"}},
+		},
+		{
+			// Кавычки учитываются только в начале значения
+			name:      "quote inside a field",
+			input:     "a\"b,c\n",
+			delimiter: ",",
+			quote:     `"`,
+			expected:  [][]string{{`a"b`, "c"}},
+		},
+		{
+			name:      "without quotes",
+			input:     "\"a\"\t\"b\"\n",
+			delimiter: "\t",
+			expected:  [][]string{{`"a"`, `"b"`}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := &csvReader{reader: bufio.NewReader(strings.NewReader(test.input)), delimiter: test.delimiter, quote: test.quote}
+			var records [][]string
+			for {
+				record, err := reader.read()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				records = append(records, record)
+			}
+			if fmt.Sprintf("%q", records) != fmt.Sprintf("%q", test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, records)
+			}
+		})
+	}
+}
+
+func TestCsvReaderUnterminatedQuote(t *testing.T) {
+	reader := &csvReader{reader: bufio.NewReader(strings.NewReader("a,\"b\nc\n")), delimiter: ",", quote: `"`}
+	if _, err := reader.read(); err == nil {
+		t.Error("expected an error for an unterminated quoted value")
+	}
+}
+
+func TestParseCsv(t *testing.T) {
+	const input = `# exported list
+id,"resource, names",ip,status
+1,"a.com|B.com.",1.1.1.1|10.0.0.0/8,blocked
+2,"c.com",not-an-ip,unblocked
+3,"bad_domain!",2001:db8::1,blocked
+4,"d.com"
+`
+	quote := `"`
+	tests := []struct {
+		name     string
+		options  CsvOptions
+		expected []string
+	}{
+		{
+			name: "columns by name",
+			options: CsvOptions{
+				Quote: &quote, SkipRows: 1, Header: true, Separator: "|",
+				IPColumns:     []CsvColumn{{Name: "IP"}},
+				DomainColumns: []CsvColumn{{Name: "resource, names"}},
+			},
+			expected: []string{"ip:1.1.1.1", "ip:10.0.0.0/8", "a.com", "b.com", "c.com", "ip:2001:db8::1", "d.com"},
+		},
+		{
+			name: "filter by value",
+			options: CsvOptions{
+				SkipRows: 2, Separator: "|",
+				DomainColumns: []CsvColumn{{Index: 1}},
+				Filter:        &CsvFilter{Column: CsvColumn{Index: 3}, Values: []string{"blocked"}},
+			},
+			expected: []string{"a.com", "b.com"},
+		},
+		{
+			name: "inverted regexp filter",
+			options: CsvOptions{
+				SkipRows: 1, Header: true,
+				DomainColumns: []CsvColumn{{Index: 1}},
+				Filter:        &CsvFilter{Column: CsvColumn{Name: "status"}, Regexp: "^block", Invert: true},
+			},
+			expected: []string{"c.com"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []string
+			options := test.options
+			err := parseCsv(strings.NewReader(input), Source{URL: "test", Csv: &options}, func(entry Entry) {
+				if entry.IsIP {
+					entries = append(entries, "ip:"+entry.Value)
+				} else {
+					entries = append(entries, entry.Value)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, entries)
+			}
+		})
+	}
+}
+
+func TestParseCsvColumnNameWithoutHeader(t *testing.T) {
+	source := Source{URL: "test", Csv: &CsvOptions{DomainColumns: []CsvColumn{{Name: "domain"}}}}
+	if err := parseCsv(strings.NewReader("a.com\n"), source, func(Entry) {}); err == nil {
+		t.Error("expected an error for a column name without 'header'")
+	}
+}
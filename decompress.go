@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Форматы сжатия и архивов, которые распаковываются автоматически
+const (
+	formatNone  = ""
+	formatGzip  = "gzip"
+	formatZstd  = "zstd"
+	formatXz    = "xz"
+	formatBzip2 = "bzip2"
+	formatZip   = "zip"
+	formatTar   = "tar"
+)
+
+// maxUnpackDepth ограничивает количество вложенных слоёв сжатия (например: .tar.gz - два слоя)
+const maxUnpackDepth = 4
+
+// detectFormat определяет формат сжатия или архива: сначала по сигнатуре (magic bytes),
+// затем по заголовку Content-Encoding и, наконец, по расширению файла
+func detectFormat(data []byte, name, contentEncoding string) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return formatGzip
+	case bytes.HasPrefix(data, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return formatZstd
+	case bytes.HasPrefix(data, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return formatXz
+	case len(data) > 3 && bytes.HasPrefix(data, []byte("BZh")) && data[3] >= '1' && data[3] <= '9':
+		return formatBzip2
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || bytes.HasPrefix(data, []byte("PK\x05\x06")):
+		return formatZip
+	case len(data) > 262 && bytes.Equal(data[257:262], []byte("ustar")):
+		return formatTar
+	}
+
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		return formatGzip
+	case "zstd":
+		return formatZstd
+	case "xz":
+		return formatXz
+	case "bzip2":
+		return formatBzip2
+	}
+
+	switch fileExtension(name) {
+	case ".gz", ".tgz":
+		return formatGzip
+	case ".zst", ".zstd":
+		return formatZstd
+	case ".xz", ".txz":
+		return formatXz
+	case ".bz2", ".tbz2":
+		return formatBzip2
+	case ".zip":
+		return formatZip
+	case ".tar":
+		return formatTar
+	}
+
+	return formatNone
+}
+
+// fileExtension возвращает расширение файла в нижнем регистре (для ссылок - без query-параметров)
+func fileExtension(name string) string {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		name = u.Path
+	}
+	return strings.ToLower(path.Ext(name))
+}
+
+// trimCompressionExtension убирает из имени расширение сжатия, чтобы определить формат следующего слоя
+// (например: list.tar.gz -> list.tar, list.tgz -> list.tar)
+func trimCompressionExtension(name string) string {
+	ext := fileExtension(name)
+	switch ext {
+	case ".tgz", ".txz", ".tbz2":
+		return name[:len(name)-len(ext)] + ".tar"
+	case ".gz", ".zst", ".zstd", ".xz", ".bz2":
+		return name[:len(name)-len(ext)]
+	}
+	return name
+}
+
+// magicSize количество байт в начале файла, по которым определяется формат (сигнатура tar находится по смещению 257)
+const magicSize = 512
+
+// unpackDocument потоково распаковывает сжатый файл и извлекает из архива файлы, подходящие под archivePath.
+// Для каждого итогового файла вызывается handle. Размер распакованных данных ограничивается maxSize
+func unpackDocument(document sourceDocument, archivePath string, maxSize int64, handle func(name string, input io.Reader) error) error {
+	file, err := os.Open(document.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return unpackStream(document.Name, file, file, document.Encoding, archivePath, maxSize, 0, handle)
+}
+
+// unpackStream снимает слои сжатия с потока input. file - исходный файл, если input читается из него
+// без преобразований (нужен zip-архиву для произвольного доступа), иначе nil
+func unpackStream(name string, input io.Reader, file *os.File, contentEncoding, archivePath string, maxSize int64, depth int, handle func(name string, input io.Reader) error) error {
+	input = newSizeLimitReader(input, maxSize)
+
+	for ; depth < maxUnpackDepth; depth++ {
+		buffered := bufio.NewReaderSize(input, magicSize)
+		magic, _ := buffered.Peek(magicSize)
+		format := detectFormat(magic, name, contentEncoding)
+		// Content-Encoding относится только к внешнему слою
+		contentEncoding = ""
+
+		switch format {
+		case formatGzip, formatZstd, formatXz, formatBzip2:
+			reader, err := newDecompressor(format, buffered)
+			if err != nil {
+				return fmt.Errorf("cannot decompress %s file '%s': %v", format, name, err)
+			}
+			defer reader.Close()
+			logInfo.Printf("decompressing the file '%s' (%s)...", name, format)
+			input = newSizeLimitReader(&decompressErrorReader{reader: reader, format: format, name: name}, maxSize)
+			name = trimCompressionExtension(name)
+			file = nil
+
+		case formatZip:
+			return extractZip(name, buffered, file, archivePath, maxSize, depth, handle)
+
+		case formatTar:
+			return extractTar(name, buffered, archivePath, maxSize, depth, handle)
+
+		default:
+			if archivePath != "" {
+				return fmt.Errorf("'archivePath' is set, but the file '%s' is not a zip or tar archive", name)
+			}
+			return handle(name, buffered)
+		}
+	}
+
+	return fmt.Errorf("the file '%s' has more than %d compression layers", name, maxUnpackDepth)
+}
+
+// newDecompressor создаёт потоковый распаковщик для указанного формата сжатия
+func newDecompressor(format string, input io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case formatGzip:
+		return gzip.NewReader(input)
+	case formatZstd:
+		zstdReader, err := zstd.NewReader(input)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReader.IOReadCloser(), nil
+	case formatXz:
+		xzReader, err := xz.NewReader(input)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xzReader), nil
+	case formatBzip2:
+		return io.NopCloser(bzip2.NewReader(input)), nil
+	}
+	return nil, fmt.Errorf("unsupported compression format '%s'", format)
+}
+
+// decompressErrorReader добавляет к ошибкам распаковки имя файла и формат сжатия
+type decompressErrorReader struct {
+	reader io.Reader
+	format string
+	name   string
+}
+
+func (r *decompressErrorReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("cannot decompress %s file '%s': %v", r.format, r.name, err)
+	}
+	return n, err
+}
+
+// archiveMatcher выбирает файлы архива по шаблону archivePath и запоминает имена файлов для сообщений об ошибках
+type archiveMatcher struct {
+	archive     string
+	archivePath string
+	names       []string
+	matched     int
+}
+
+// matches проверяет, нужно ли извлекать файл архива. Если archivePath не указан,
+// архив должен содержать ровно один файл
+func (m *archiveMatcher) matches(name string) (bool, error) {
+	m.names = append(m.names, name)
+	if m.archivePath == "" {
+		if m.matched++; m.matched > 1 {
+			return false, fmt.Errorf("the archive '%s' contains several files (%s), set 'archivePath' to choose the file to parse",
+				m.archive, strings.Join(m.names, ", "))
+		}
+		return true, nil
+	}
+	ok, err := path.Match(m.archivePath, strings.TrimPrefix(name, "./"))
+	if err != nil {
+		return false, fmt.Errorf("invalid 'archivePath' pattern '%s': %v", m.archivePath, err)
+	}
+	if ok {
+		m.matched++
+	}
+	return ok, nil
+}
+
+// done проверяет, что из архива был извлечён хотя бы один файл
+func (m *archiveMatcher) done() error {
+	if m.matched == 0 {
+		return fmt.Errorf("no files matching '%s' in the archive '%s' (files: %s)", m.archivePath, m.archive, strings.Join(m.names, ", "))
+	}
+	return nil
+}
+
+// extractZip извлекает файлы из zip архива. Zip требует произвольного доступа к данным,
+// поэтому архив, полученный после распаковки или из другого архива, сохраняется во временный файл
+func extractZip(name string, input io.Reader, file *os.File, archivePath string, maxSize int64, depth int, handle func(name string, input io.Reader) error) error {
+	if file == nil {
+		tmpFile, err := os.CreateTemp("", "generate-geoip-geosite-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFile.Name())
+		defer tmpFile.Close()
+		if _, err := io.Copy(tmpFile, input); err != nil {
+			return fmt.Errorf("cannot read zip archive '%s': %v", name, err)
+		}
+		file = tmpFile
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	zipReader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("cannot open zip archive '%s': %v", name, err)
+	}
+
+	// Оглавление zip известно заранее, поэтому сначала выбираем файлы, а потом читаем их
+	matcher := &archiveMatcher{archive: name, archivePath: archivePath}
+	var members []*zip.File
+	for _, member := range zipReader.File {
+		if member.FileInfo().IsDir() {
+			continue
+		}
+		ok, err := matcher.matches(member.Name)
+		if err != nil {
+			return err
+		}
+		if ok {
+			members = append(members, member)
+		}
+	}
+	if err := matcher.done(); err != nil {
+		return err
+	}
+
+	for _, member := range members {
+		reader, err := member.Open()
+		if err != nil {
+			return fmt.Errorf("cannot read '%s' from zip archive '%s': %v", member.Name, name, err)
+		}
+		// Файлы внутри архива тоже могут быть сжаты
+		err = unpackStream(name+"!"+member.Name, reader, nil, "", "", maxSize, depth+1, handle)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar потоково извлекает файлы из tar архива
+func extractTar(name string, input io.Reader, archivePath string, maxSize int64, depth int, handle func(name string, input io.Reader) error) error {
+	matcher := &archiveMatcher{archive: name, archivePath: archivePath}
+	tarReader := tar.NewReader(input)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read tar archive '%s': %v", name, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		ok, err := matcher.matches(header.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		// Файлы внутри архива тоже могут быть сжаты
+		if err := unpackStream(name+"!"+header.Name, tarReader, nil, "", "", maxSize, depth+1, handle); err != nil {
+			return err
+		}
+	}
+	return matcher.done()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// parseDnsmasq извлекает домены из строк конфигурации dnsmasq server=, ipset=, nftset= и address=
+// (Например: server=/example.com/example.org/1.1.1.1, ipset=/example.com/vpn,vpn6).
+// Dnsmasq применяет эти строки ко всем поддоменам, поэтому домены записываются как "*.example.com".
+// Если в источнике указан список sets, берутся только строки ipset= и nftset= с этими именами
+func parseDnsmasq(input io.Reader, source Source, emit func(Entry)) error {
+	sets := map[string]bool{}
+	for _, name := range source.Sets {
+		sets[name] = true
+	}
+
+	invalid := 0
+	err := scanLines(input, func(line string) {
+		line = strings.TrimSpace(line)
+
+		// Пропускаем пустые строки и комментарии
+		if line == "" || strings.HasPrefix(line, "#") {
+			return
+		}
+
+		option, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return
+		}
+		option = strings.TrimSpace(option)
+
+		// Значение имеет вид /domain1/domain2/.../argument
+		value = strings.TrimSpace(value)
+		if !strings.HasPrefix(value, "/") {
+			return
+		}
+		parts := strings.Split(value[1:], "/")
+		if len(parts) < 2 {
+			return
+		}
+		domains, argument := parts[:len(parts)-1], parts[len(parts)-1]
+
+		switch option {
+		case "server", "address":
+			if len(sets) > 0 {
+				return
+			}
+		case "ipset", "nftset":
+			if len(sets) > 0 && !dnsmasqSetMatches(option, argument, sets) {
+				return
+			}
+		default:
+			return
+		}
+
+		for _, domain := range domains {
+			// "#" означает все домены, а пустой домен - домены без точки
+			domain = strings.ToLower(strings.Trim(strings.TrimPrefix(domain, "*"), "."))
+			if domain == "" || domain == "#" {
+				continue
+			}
+			// Отсекаем IP-адреса (например, лишний "/" после адреса сервера) и прочий мусор
+			if !rgxDomain.MatchString(domain) || strings.Contains(domain, "*") || rgxIPv4.MatchString(domain) {
+				invalid++
+				continue
+			}
+			emit(Entry{Value: "*." + domain})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if invalid > 0 {
+		logWarn.Printf("source '%s': skipped %d invalid domains", source.URL, invalid)
+	}
+	return nil
+}
+
+// dnsmasqSetMatches проверяет, есть ли среди наборов строки ipset= или nftset= один из нужных.
+// Наборы перечисляются через запятую, для nftset имя набора - последняя часть [4|6#]family#table#set
+func dnsmasqSetMatches(option, argument string, sets map[string]bool) bool {
+	for _, set := range strings.Split(argument, ",") {
+		set = strings.TrimSpace(set)
+		if option == "nftset" {
+			if i := strings.LastIndex(set, "#"); i >= 0 {
+				set = set[i+1:]
+			}
+		}
+		if sets[set] {
+			return true
+		}
+	}
+	return false
+}
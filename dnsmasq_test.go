@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testDnsmasqConfig = `# comment
+server=/a.com/b.org/1.1.1.1
+server=/#/8.8.8.8
+server=/c.net/1.1.1.1/
+address=/*.ads.com/0.0.0.0
+ipset=/d.com/vpn,vpn6
+nftset=/e.com/4#inet#fw4#vpn
+ipset=/f.com/other
+server=/bad_domain!/1.1.1.1
+cache-size=1000
+`
+
+func TestParseDnsmasq(t *testing.T) {
+	tests := []struct {
+		name     string
+		sets     []string
+		expected []string
+	}{
+		{name: "all", expected: []string{"*.a.com", "*.b.org", "*.c.net", "*.ads.com", "*.d.com", "*.e.com", "*.f.com"}},
+		{name: "sets", sets: []string{"vpn"}, expected: []string{"*.d.com", "*.e.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []string
+			source := Source{URL: "test", Sets: test.sets}
+			err := parseDnsmasq(strings.NewReader(testDnsmasqConfig), source, func(entry Entry) {
+				entries = append(entries, entry.Value)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, entries)
+			}
+		})
+	}
+}
@@ -2,17 +2,59 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
+	"path/filepath"
 	"strings"
-
-	"golang.org/x/text/encoding/charmap"
+	"sync"
+	"time"
 )
 
+// sourceResult хранит результат скачивания и парсинга одного источника
+type sourceResult struct {
+	lists   []*parsedList // Распарсенные записи, разложенные по спискам (в порядке появления)
+	changed bool          // true, если файл изменился с прошлого скачивания (или кеш не используется)
+	err     error         // Ошибка скачивания или парсинга
+}
+
+// listKey определяет файлы, в которые пишутся записи: include/exclude и категория
+type listKey struct {
+	Exclude  bool
+	Category string
+}
+
+// filenames возвращает имена файлов для IP-адресов и доменов списка
+func (k listKey) filenames(inputDir string) (string, string) {
+	// Определяем тип списка (для названия файла)
+	StartFilename := "include"
+	if k.Exclude {
+		StartFilename = "exclude"
+	}
+	return inputDir + StartFilename + "-ip-" + k.Category + ".lst", inputDir + StartFilename + "-domain-" + k.Category + ".lst"
+}
+
+// parsedList записи источника, которые пишутся в один список
+type parsedList struct {
+	key         listKey
+	ipAddresses *orderedSet // Распарсенные IP-адреса
+	domains     *orderedSet // Распарсенные домены
+}
+
+// list возвращает записи источника для списка key
+func (r *sourceResult) list(key listKey) *parsedList {
+	for _, list := range r.lists {
+		if list.key == key {
+			return list
+		}
+	}
+	return nil
+}
+
 func Downloader(configs *Config) error {
 	// Проверяем наличие директории InputDir
 	if _, err := os.Stat(configs.InputDir); os.IsNotExist(err) {
@@ -24,252 +66,641 @@ func Downloader(configs *Config) error {
 		logInfo.Printf("the directory '%s' was missing, but it was created:", configs.InputDir)
 	}
 
-	// Перебираем источники
-	for _, source := range configs.Sources {
+	// Открываем кеш скачанных файлов (если указан)
+	cache, err := newDownloadCache(configs.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	// Разбираем глобальный прокси
+	proxy, err := parseProxyOption(configs.Proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %v", err)
+	}
+
+	// Готовим общие для всех источников ресурсы скачивания
+	f := &fetcher{
+		options: configs.Download,
+		limiter: newHostLimiter(configs.PerHost),
+		cache:   cache,
+		proxies: newProxyPool(),
+		proxy:   proxy,
+	}
+	defer f.proxies.Close()
+
+	// Скачиваем и парсим все источники параллельно
+	results := processSources(configs, f)
+
+	// Группируем источники по файлам, в которые они пишут: источники с одинаковой категорией
+	// объединяются, а не перезаписывают друг друга. Порядок групп - порядок первого упоминания в файле.
+	// Кроме списка самого источника, парсер может вернуть записи для других списков (например, исключения)
+	var groups []*categoryGroup
+	groupIndex := map[listKey]*categoryGroup{}
+	addToGroup := func(key listKey, i int) {
+		group, ok := groupIndex[key]
+		if !ok {
+			group = &categoryGroup{key: key}
+			// Собираем имена файлов
+			group.IpFilename, group.DomainFilename = key.filenames(configs.InputDir)
+			groupIndex[key] = group
+			groups = append(groups, group)
+		}
+		if n := len(group.indexes); n == 0 || group.indexes[n-1] != i {
+			group.indexes = append(group.indexes, i)
+		}
+	}
+	for i, source := range configs.Sources {
+		for _, category := range source.OutputCategories() {
+			addToGroup(listKey{Exclude: source.IsExclude, Category: category}, i)
+		}
+		for _, list := range results[i].lists {
+			addToGroup(list.key, i)
+		}
+	}
 
-		// Определяем тип источника (для названия файла)
-		StartFilename := "include"
-		if source.IsExclude {
-			StartFilename = "exclude"
+	// Сообщаем об ошибках источников
+	var errs []error
+	var requiredFailed bool
+	var changed []string
+	for i, source := range configs.Sources {
+		result := results[i]
+		if result.err != nil {
+			logWarn.Printf("source '%s' failed: %v", source.URL, result.err)
+			errs = append(errs, fmt.Errorf("source '%s': %v", source.URL, result.err))
+			if source.Required {
+				requiredFailed = true
+			}
+			continue
+		}
+		if result.changed {
+			changed = append(changed, source.URL)
 		}
-		// Собираем имена файлов
-		var IpFilename = configs.InputDir + StartFilename + "-ip-" + source.Category + ".lst"
-		var DomainFilename = configs.InputDir + StartFilename + "-domain-" + source.Category + ".lst"
+	}
 
-		// Cкачиваем файл
-		logInfo.Printf("downloading the file '%s'...", source.URL)
-		data, err := downloadURL(source.URL)
-		if err != nil {
-			return fmt.Errorf("error downloading file: %v", err)
+	// Записываем результаты групп
+	for _, group := range groups {
+		// Если хотя бы один источник категории не удалось обработать, ранее записанные файлы
+		// категории не трогаем, иначе из них пропали бы записи этого источника
+		groupFailed := false
+		for _, i := range group.indexes {
+			if results[i].err != nil {
+				groupFailed = true
+			}
+		}
+		if groupFailed {
+			for _, fileName := range []string{group.IpFilename, group.DomainFilename} {
+				if _, err := os.Stat(fileName); err == nil {
+					logWarn.Printf("the previously written file '%s' is kept", fileName)
+				}
+			}
+			continue
 		}
 
-		// Парсим скачанный файл в зависимости от указанного source.ContentType
-		logInfo.Printf("parsing the file...")
-		parserFunc, ok := parsers[source.ContentType]
-		if !ok {
-			parserFunc = parseDefaultList
-			return fmt.Errorf("invalid data handler type: %s", source.ContentType)
+		// Объединяем записи всех источников категории, убирая дубликаты
+		ipAddresses := newOrderedSet()
+		domains := newOrderedSet()
+		for _, i := range group.indexes {
+			list := results[i].list(group.key)
+			if list == nil {
+				continue
+			}
+			newIPs := ipAddresses.addAll(list.ipAddresses.items)
+			newDomains := domains.addAll(list.domains.items)
+			if len(group.indexes) > 1 {
+				logInfo.Printf("source '%s' contributed %d IP addresses (%d new) and %d domains (%d new)",
+					configs.Sources[i].URL, len(list.ipAddresses.items), newIPs, len(list.domains.items), newDomains)
+			}
 		}
-		ipAddresses, domains := parserFunc(string(data))
 
 		// Если были распарсены IP-адреса, то сохраняем их в файл
-		if len(ipAddresses) != 0 {
-			err = writeToFile(ipAddresses, IpFilename)
-			if err != nil {
+		if len(ipAddresses.items) != 0 {
+			if err := writeToFile(ipAddresses.items, group.IpFilename); err != nil {
 				return fmt.Errorf("error writing IP addresses to file: %v", err)
 			}
-			logInfo.Printf("parsed IP addresses are written in '%s'", IpFilename)
+			logInfo.Printf("parsed IP addresses are written in '%s'", group.IpFilename)
 		}
 
 		// Если были распарсены Домены, то сохраняем их в файл
-		if len(domains) != 0 {
-			err = writeToFile(domains, DomainFilename)
-			if err != nil {
+		if len(domains.items) != 0 {
+			if err := writeToFile(domains.items, group.DomainFilename); err != nil {
 				return fmt.Errorf("error writing domains to file: %v", err)
 			}
-			logInfo.Printf("parsed domains are written in '%s'", DomainFilename)
+			logInfo.Printf("parsed domains are written in '%s'", group.DomainFilename)
+		}
+	}
+
+	// Сообщаем, какие источники изменились с прошлого запуска
+	if cache != nil {
+		logInfo.Printf("%d of %d sources changed since the last download", len(changed), len(configs.Sources))
+		for _, sourceURL := range changed {
+			logInfo.Printf("changed: '%s'", sourceURL)
 		}
+	}
 
+	// Ошибку возвращаем только после того, как были опробованы все источники
+	if len(errs) == 0 {
+		return nil
 	}
-	return nil
+	err = fmt.Errorf("%d of %d sources failed: %v", len(errs), len(configs.Sources), errors.Join(errs...))
+	// В режиме fail-soft сбой необязательных источников не прерывает генерацию
+	if configs.FailSoft && !requiredFailed {
+		return &PartialError{Failed: len(errs), Total: len(configs.Sources), Err: err}
+	}
+	return err
 }
 
-func downloadURL(url string) ([]byte, error) {
-	// Получаем ответ от get запроса на указанный url
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
+// PartialError возвращается Downloader'ом в режиме fail-soft, если не удалось обработать
+// только необязательные источники. Для них остались ранее записанные списки
+type PartialError struct {
+	Failed int   // Количество источников, которые не удалось обработать
+	Total  int   // Общее количество источников
+	Err    error // Объединённые ошибки источников
+}
+
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
+// categoryGroup источники, которые пишут в одни и те же файлы категории
+type categoryGroup struct {
+	key            listKey
+	IpFilename     string // Файл для IP-адресов категории
+	DomainFilename string // Файл для доменов категории
+	indexes        []int  // Индексы источников группы в Config.Sources
+}
+
+// orderedSet множество строк, сохраняющее порядок добавления
+type orderedSet struct {
+	items []string
+	seen  map[string]bool
+}
+
+func newOrderedSet() *orderedSet {
+	return &orderedSet{seen: map[string]bool{}}
+}
+
+// add добавляет строку в множество и сообщает, была ли она новой
+func (s *orderedSet) add(item string) bool {
+	if s.seen[item] {
+		return false
 	}
-	defer resp.Body.Close()
+	s.seen[item] = true
+	s.items = append(s.items, item)
+	return true
+}
 
-	// Если ответ не 200, выдаём ошибку
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP response error: %s", resp.Status)
+// addAll добавляет строки в множество и возвращает количество новых
+func (s *orderedSet) addAll(items []string) int {
+	added := 0
+	for _, item := range items {
+		if s.add(item) {
+			added++
+		}
 	}
+	return added
+}
 
-	// Читаем ответ в переменную data
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+// processSources скачивает и парсит источники пулом из configs.Workers воркеров.
+// Результаты возвращаются в том же порядке, что и configs.Sources
+func processSources(configs *Config, f *fetcher) []sourceResult {
+	results := make([]sourceResult, len(configs.Sources))
+
+	workers := configs.Workers
+	if workers < 1 {
+		workers = 1
 	}
 
-	return data, nil
+	// Раздаём воркерам индексы источников
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = processSource(configs.Sources[i], f)
+			}
+		}()
+	}
+	for i := range configs.Sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
 }
 
-func parseJsonListDomains(jsonData string) ([]string, []string) {
-	var domains []string
-	err := json.Unmarshal([]byte(jsonData), &domains)
+// processSource скачивает (или читает с диска) и парсит один источник
+func processSource(source Source, f *fetcher) sourceResult {
+	// Проверяем тип обработчика до скачивания, чтобы не качать файл впустую
+	parserFunc, ok := parsers[source.ContentType]
+	if !ok {
+		return sourceResult{err: fmt.Errorf("invalid data handler type: %s", source.ContentType)}
+	}
+	encodingName, err := source.sourceEncoding()
 	if err != nil {
-		logWarn.Print(err)
-		return nil, nil
+		return sourceResult{err: err}
 	}
-	return nil, domains
-}
 
-func parseJsonListIPs(jsonData string) ([]string, []string) {
-	var ips []string
-	err := json.Unmarshal([]byte(jsonData), &ips)
+	// Получаем содержимое источника
+	fetched, changed, err := f.fetchSource(source)
 	if err != nil {
-		logWarn.Print(err)
-		return nil, nil
+		return sourceResult{err: err}
+	}
+
+	// Временные файлы скачивания больше не нужны после парсинга
+	defer func() {
+		for _, document := range fetched {
+			document.cleanup()
+		}
+	}()
+
+	// Проверяем хеши и подписи до распаковки и парсинга
+	if err := f.verifyIntegrity(source, fetched); err != nil {
+		return sourceResult{err: fmt.Errorf("integrity check failed: %v", err)}
+	}
+	f.commitCache(fetched)
+
+	// Записи собираем в множества, чтобы сразу убирать дубликаты, в том числе между файлами источника
+	var result sourceResult
+	emit := func(entry Entry) {
+		value := strings.TrimSpace(entry.Value)
+		if value == "" {
+			return
+		}
+		// Запись-исключение попадает в exclude-список категории источника
+		key := listKey{Exclude: source.IsExclude || entry.Exclude, Category: source.Category}
+		if entry.Category != "" {
+			key.Category = entry.Category
+		}
+		list := result.list(key)
+		if list == nil {
+			list = &parsedList{key: key, ipAddresses: newOrderedSet(), domains: newOrderedSet()}
+			result.lists = append(result.lists, list)
+		}
+		if entry.IsIP {
+			list.ipAddresses.add(value)
+		} else {
+			list.domains.add(value)
+		}
+	}
+
+	// Распаковываем сжатые файлы, извлекаем нужные файлы из архивов и потоково парсим каждый из них
+	// в зависимости от указанного source.ContentType
+	maxSize := int64(source.DownloadOptions(f.options).MaxBodySize)
+	for _, document := range fetched {
+		err := unpackDocument(document, source.ArchivePath, maxSize, func(name string, input io.Reader) error {
+			logInfo.Printf("parsing the file '%s'...", name)
+			// Парсеру нужен разбираемый файл, чтобы открывать файлы, на которые он ссылается
+			documentSource := source
+			documentSource.document, documentSource.fetcher = name, f
+
+			// Текстовые файлы перекодируем в UTF-8, только если кодировка задана (явно, auto или по умолчанию для типа).
+			// Без неё файл, как и раньше, передаётся парсеру без изменений
+			var decoder *decodingReader
+			if encodingName != "" {
+				var err error
+				if decoder, err = newDecodingReader(input, encodingName); err != nil {
+					return err
+				}
+				if encodingName == encodingAuto || decoder.encoding != "utf-8" {
+					logInfo.Printf("the file '%s' is decoded from %s", name, decoder.encoding)
+				}
+				input = decoder
+			}
+
+			if err := parserFunc(input, documentSource, emit); err != nil {
+				return fmt.Errorf("cannot parse the file '%s': %v", name, err)
+			}
+			if decoder != nil && decoder.invalid() > 0 {
+				logWarn.Printf("source '%s': %d invalid byte sequences for %s in '%s' were replaced with U+FFFD", source.URL, decoder.invalid(), decoder.encoding, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return sourceResult{err: err}
+		}
 	}
-	return ips, nil
+
+	result.changed = changed
+	return result
 }
 
-func parseJsonRublacklistDPI(jsonData string) ([]string, []string) {
-	// Создаём стркутуру
-	type Data struct {
-		Domains     []string `json:"domains"`
-		Name        string   `json:"name"`
-		Restriction struct {
-			Code string `json:"code"`
-		} `json:"restriction"`
+// fetcher общие для всех источников ресурсы скачивания
+type fetcher struct {
+	options DownloadOptions // Глобальные параметры скачивания
+	limiter *hostLimiter    // Ограничение одновременных запросов к хосту
+	cache   *downloadCache  // Кеш скачанных файлов (nil - кеш отключен)
+	proxies *proxyPool      // Запущенные outbound'ы sing-box
+	proxy   *ProxyOption    // Глобальный прокси (nil - прокси из переменных окружения)
+}
+
+// fetchSource возвращает файлы источника: скачивает их по HTTP(S) или читает с диска
+// (ссылки file://, пути к файлам и директориям, шаблоны путей)
+func (f *fetcher) fetchSource(source Source) ([]sourceDocument, bool, error) {
+	if !isRemoteSource(source.URL) {
+		logInfo.Printf("reading the local source '%s'...", source.URL)
+		documents, err := readLocalSource(source.URL)
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading local source: %v", err)
+		}
+		return documents, true, nil
 	}
 
-	// Парсим json в структуру
-	var data []Data
-	err := json.Unmarshal([]byte(jsonData), &data)
+	// Собираем заголовки и учётные данные источника
+	request, err := source.RequestOptions()
 	if err != nil {
-		logWarn.Print(err)
-		return nil, nil
+		return nil, false, err
 	}
 
-	// Добавлям домены в общий список
-	var domains []string
-	for _, item := range data {
-		domains = append(domains, item.Domains...)
+	// Готовим клиент с таймаутами и прокси источника
+	options := source.DownloadOptions(f.options)
+	client, err := f.newClient(source, options)
+	if err != nil {
+		return nil, false, err
 	}
+	defer client.CloseIdleConnections()
+
+	// Cкачиваем файл, соблюдая ограничение на количество одновременных запросов к хосту
+	host := hostOf(source.URL)
+	f.limiter.acquire(host)
+	logInfo.Printf("downloading the file '%s'...", source.URL)
+	document, changed, err := downloadURL(client, source.URL, f.cache, options, request)
+	f.limiter.release(host)
+	if err != nil {
+		return nil, false, fmt.Errorf("error downloading file: %v", err)
+	}
+	return []sourceDocument{document}, changed, nil
+}
 
-	return nil, domains
+// resolveRelated возвращает ссылку или путь к файлу name относительно разбираемого файла источника
+func (s Source) resolveRelated(name string) (string, error) {
+	if isRemoteSource(s.document) {
+		base, err := url.Parse(s.document)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(name)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if isRemoteSource(name) || filepath.IsAbs(name) {
+		return name, nil
+	}
+	return filepath.Join(filepath.Dir(s.document), name), nil
 }
 
-func parseCsvDumpAntizapret(input string) ([]string, []string) {
-	var ipAddresses []string
-	var domains []string
+// openRelated открывает файл location, на который ссылается разбираемый файл источника
+// (например, include: в списках v2fly). Файл скачивается с параметрами источника, а заголовки и учётные данные
+// отправляются, только если он на том же хосте
+func (s Source) openRelated(location string) (io.ReadCloser, error) {
+	if s.fetcher == nil {
+		return nil, fmt.Errorf("the source cannot open related files")
+	}
 
-	// Декодируем входную строку из Windows-1251 в UTF-8
-	decoder := charmap.Windows1251.NewDecoder()
-	decodedInput, _ := decoder.String(input)
+	documents, _, err := s.fetcher.fetchSource(s.relatedSource(location))
+	if err != nil {
+		return nil, err
+	}
+	s.fetcher.commitCache(documents)
+	if len(documents) != 1 {
+		for _, document := range documents {
+			document.cleanup()
+		}
+		return nil, fmt.Errorf("expected one file, but '%s' has %d", location, len(documents))
+	}
+
+	file, err := os.Open(documents[0].Path)
+	if err != nil {
+		documents[0].cleanup()
+		return nil, err
+	}
+	maxSize := int64(s.DownloadOptions(s.fetcher.options).MaxBodySize)
+	return &relatedFile{Reader: newSizeLimitReader(file, maxSize), file: file, document: documents[0]}, nil
+}
 
-	lines := strings.Split(decodedInput, "\n")
-	for _, line := range lines {
-		// Разделяем строку на столбцы по символу ";"
-		columns := strings.Split(line, ";")
+// relatedFile открытый файл, на который ссылается источник. При закрытии удаляет временный файл
+type relatedFile struct {
+	io.Reader
+	file     *os.File
+	document sourceDocument
+}
 
-		// Пропускаем первую строку (в ней один столбец)
-		if len(columns) == 1 {
-			continue
-		}
+func (r *relatedFile) Close() error {
+	err := r.file.Close()
+	r.document.cleanup()
+	return err
+}
 
-		// Извлекаем IP-адреса из первого столбца
-		ips := strings.Split(columns[0], "|")
-		ipAddresses = append(ipAddresses, ips...)
+// newClient создаёт HTTP-клиент источника; прокси источника имеет приоритет над глобальным
+func (f *fetcher) newClient(source Source, options DownloadOptions) (*http.Client, error) {
+	client := newHTTPClient(options)
+	client.CheckRedirect = source.checkRedirect
 
-		// Если есть второй столбца, извлекаем домены из нее
-		if len(columns) > 1 {
-			domainMatches := strings.Split(columns[1], "|")
-			domains = append(domains, domainMatches...)
+	proxy := f.proxy
+	if source.Proxy != nil {
+		proxy = source.Proxy
+	}
+	if proxy != nil {
+		if err := f.proxies.configure(client.Transport.(*http.Transport), proxy); err != nil {
+			return nil, err
 		}
+		logInfo.Printf("the file '%s' is downloaded via %s", source.URL, proxy)
 	}
+	return client, nil
+}
 
-	// Убираем дубликаты
-	ipAddresses = uniqueSlice(ipAddresses)
-	domains = uniqueSlice(domains)
+// hostLimiter ограничивает количество одновременных запросов к одному хосту
+type hostLimiter struct {
+	limit int
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
 
-	return ipAddresses, domains
+// newHostLimiter создаёт ограничитель; limit <= 0 означает отсутствие ограничений
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: limit, slots: map[string]chan struct{}{}}
 }
 
-var (
-	rgxIPv4   = regexp.MustCompile(`^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(/(3[0-2]|2[0-9]|1[0-9]|[0-9]))?$`)
-	rgxIPv6   = regexp.MustCompile(`^s*((([0-9A-Fa-f]{1,4}:){7}([0-9A-Fa-f]{1,4}|:))|(([0-9A-Fa-f]{1,4}:){6}(:[0-9A-Fa-f]{1,4}|((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3})|:))|(([0-9A-Fa-f]{1,4}:){5}(((:[0-9A-Fa-f]{1,4}){1,2})|:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3})|:))|(([0-9A-Fa-f]{1,4}:){4}(((:[0-9A-Fa-f]{1,4}){1,3})|((:[0-9A-Fa-f]{1,4})?:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(([0-9A-Fa-f]{1,4}:){3}(((:[0-9A-Fa-f]{1,4}){1,4})|((:[0-9A-Fa-f]{1,4}){0,2}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(([0-9A-Fa-f]{1,4}:){2}(((:[0-9A-Fa-f]{1,4}){1,5})|((:[0-9A-Fa-f]{1,4}){0,3}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(([0-9A-Fa-f]{1,4}:){1}(((:[0-9A-Fa-f]{1,4}){1,6})|((:[0-9A-Fa-f]{1,4}){0,4}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(:(((:[0-9A-Fa-f]{1,4}){1,7})|((:[0-9A-Fa-f]{1,4}){0,5}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:)))(%.+)?s*(\/([0-9]|[1-9][0-9]|1[0-1][0-9]|12[0-8]))?$`)
-	rgxDomain = regexp.MustCompile(`^(([a-zA-Z0-9А-яёЁ\*]|[a-zA-Z0-9А-яёЁ][a-zA-Z0-9А-яёЁ\-]*[a-zA-Z0-9А-яёЁ])\.)*([A-Za-z0-9А-яёЁ]|[A-Za-z0-9А-яёЁ][A-Za-z0-9А-яёЁ\-]*[A-Za-z0-9А-яёЁ])$`)
-)
+// acquire занимает слот для хоста, ожидая освобождения, если все слоты заняты
+func (l *hostLimiter) acquire(host string) {
+	if l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	l.mu.Unlock()
+	slot <- struct{}{}
+}
 
-func parseDefaultList(input string) ([]string, []string) {
-	var ipAddresses []string
-	var domains []string
+// release освобождает слот хоста
+func (l *hostLimiter) release(host string) {
+	if l.limit <= 0 {
+		return
+	}
+	l.mu.Lock()
+	slot := l.slots[host]
+	l.mu.Unlock()
+	<-slot
+}
 
-	lines := strings.Split(input, "\n")
+// hostnameOf возвращает имя хоста из ссылки без порта
+func hostnameOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
 
-	for _, line := range lines {
+// hostOf возвращает хост из ссылки (или саму ссылку, если её не удалось разобрать)
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
 
-		// Извлекаем домен
-		if rgxDomain.MatchString(line) {
-			domains = append(domains, line)
-			continue
-		}
+// downloadURL скачивает файл по ссылке rawURL, повторяя попытки при ошибках сети и ответах 5xx.
+// Если задан кеш, отправляет условный запрос и при ответе 304 возвращает закешированное тело.
+// Второе значение сообщает, изменился ли файл
+func downloadURL(client *http.Client, rawURL string, cache *downloadCache, options DownloadOptions, request *requestOptions) (sourceDocument, bool, error) {
+	// Ограничиваем общее время скачивания, включая все повторные попытки
+	ctx := context.Background()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
 
-		// Извлекаем IPv4-адрес
-		if rgxIPv4.MatchString(line) {
-			ipAddresses = append(ipAddresses, line)
-			continue
+	// Если файл уже скачивался, будем просить сервер не отдавать его повторно без изменений
+	meta, cachedBody := cache.load(rawURL)
+
+	attempts := options.Retries + 1
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		// Перед повторной попыткой ждём с экспоненциально растущей задержкой
+		if attempt > 1 {
+			delay := retryDelay(options.RetryDelay, attempt-1)
+			logWarn.Printf("attempt %d/%d for '%s' failed: %v; retrying in %s", attempt-1, attempts, rawURL, lastErr, delay.Round(time.Millisecond))
+			if err := sleepContext(ctx, delay); err != nil {
+				break
+			}
 		}
 
-		// Извлекаем IPv6-адрес
-		if rgxIPv6.MatchString(line) {
-			ipAddresses = append(ipAddresses, line)
-			continue
+		document, changed, err := fetchURL(ctx, client, rawURL, request, cache, meta, cachedBody, options)
+		if err == nil {
+			return document, changed, nil
 		}
+		lastErr = err
 
-		// Если строка не была комментарием или пустой строкой, выводим предупреждение, что не удалось распарсить строку
-		// (так как такие строки отсекаются регулярками, нет смысла делать проверку перед регулярками)
-		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
-			logWarn.Printf("Failed to parse '%s' as an IPv4, IPv6, or domain address", line)
+		// Не повторяем запрос, если ошибка постоянная или вышло общее время
+		if !isRetryable(err) || ctx.Err() != nil {
+			break
 		}
 	}
 
-	// Убираем дубликаты
-	ipAddresses = uniqueSlice(ipAddresses)
-	domains = uniqueSlice(domains)
-
-	return ipAddresses, domains
+	if ctx.Err() == context.DeadlineExceeded {
+		return sourceDocument{}, false, fmt.Errorf("total timeout of %s exceeded: %v", options.Timeout, lastErr)
+	}
+	return sourceDocument{}, false, lastErr
 }
 
-func parseHostsFile(input string) ([]string, []string) {
-	var ips []string
-	var domains []string
+// fetchURL выполняет одну попытку скачивания файла. Тело ответа записывается во временный файл
+// (или в кеш), а не в память, и ограничивается размером options.MaxBodySize
+func fetchURL(ctx context.Context, client *http.Client, rawURL string, request *requestOptions, cache *downloadCache, meta *cacheMeta, cachedBody string, options DownloadOptions) (sourceDocument, bool, error) {
+	// Отдельный контекст попытки позволяет прервать зависшее чтение тела ответа
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	scanner := bufio.NewScanner(strings.NewReader(input))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return sourceDocument{}, false, err
+	}
+	request.apply(req)
+	meta.applyConditionalHeaders(req)
+
+	// Получаем ответ от get запроса на указанный url
+	resp, err := client.Do(req)
+	if err != nil {
+		return sourceDocument{}, false, err
+	}
+	defer resp.Body.Close()
 
-	for scanner.Scan() {
-		line := scanner.Text()
+	// Файл не изменился с прошлого скачивания, используем закешированную копию
+	if resp.StatusCode == http.StatusNotModified && meta != nil {
+		logInfo.Printf("the file '%s' has not been modified, using the cached copy", rawURL)
+		return sourceDocument{Name: rawURL, Path: cachedBody, Encoding: meta.Encoding}, false, nil
+	}
 
-		// Пропускаем пустые строки и комментарии
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
-			continue
-		}
+	// Если ответ не 200, выдаём ошибку
+	if resp.StatusCode != http.StatusOK {
+		return sourceDocument{}, false, &statusError{Status: resp.Status, StatusCode: resp.StatusCode}
+	}
 
-		// Разбиваем строку на слова
-		words := strings.Fields(line)
+	// Сервер заранее сообщил размер, превышающий допустимый, - не начинаем скачивание
+	if options.MaxBodySize > 0 && resp.ContentLength > int64(options.MaxBodySize) {
+		return sourceDocument{}, false, fmt.Errorf("the response size is %s: %w", ByteSize(resp.ContentLength), &sizeLimitError{Limit: int64(options.MaxBodySize)})
+	}
 
-		// Если в строке два слова
-		if len(words) == 2 {
-			var ip = words[0]
-			var domain = words[1]
+	// Читаем ответ, прерывая чтение, если сервер перестал присылать данные
+	var body io.Reader = resp.Body
+	if options.ReadTimeout > 0 {
+		idleReader := newIdleTimeoutReader(resp.Body, options.ReadTimeout, cancel)
+		defer idleReader.Stop()
+		body = idleReader
+	}
+	body = newSizeLimitReader(body, int64(options.MaxBodySize))
 
-			// Добавляем IP, есом он не адрес вида 127.0.0.1, 0.0.0.0, ::1 и т.п.
-			if !isLoopbackIP(ip) {
-				ips = append(ips, ip)
-			}
+	tmpFile, err := cache.createTemp()
+	if err != nil {
+		return sourceDocument{}, false, err
+	}
+	_, err = io.Copy(tmpFile, body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return sourceDocument{}, false, err
+	}
 
-			// Добавялем домен, если он не localhost
-			if domain != "localhost" {
-				domains = append(domains, domain)
-			}
-		}
+	document := sourceDocument{Name: rawURL, Path: tmpFile.Name(), Encoding: resp.Header.Get("Content-Encoding"), Temp: true}
+	// В кеш ответ попадает только после проверки хешей и подписей (см. commitCache),
+	// иначе отклонённый файл возвращался бы из кеша по ответу 304
+	if cache != nil {
+		document.cacheHeader = resp.Header
 	}
-	return ips, domains
+
+	return document, true, nil
 }
 
-// Проверяем, является ли переданный IP адрес "зацикленным" (loopback)
-func isLoopbackIP(ip string) bool {
-	loopbackPatterns := []string{"127.", "0.0.0.0", "::1"}
-	for _, pattern := range loopbackPatterns {
-		if strings.HasPrefix(ip, pattern) {
-			return true
+// commitCache сохраняет в кеш скачанные файлы, прошедшие проверку (ошибка кеша не должна мешать генерации)
+func (f *fetcher) commitCache(documents []sourceDocument) {
+	for i := range documents {
+		document := &documents[i]
+		if document.cacheHeader == nil || f.cache == nil {
+			continue
+		}
+		bodyPath, err := f.cache.store(document.Name, document.cacheHeader, document.Path)
+		if err != nil {
+			logWarn.Printf("cannot cache the file '%s': %v", document.Name, err)
+			continue
 		}
+		document.Path, document.Temp, document.cacheHeader = bodyPath, false, nil
 	}
-	return false
 }
 
 // uniqueSlice удаляет дубликаты
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProxy локальный прокси для тестов: запоминает адреса, к которым через него подключались
+type testProxy struct {
+	listener net.Listener
+	mu       sync.Mutex
+	targets  []string // Адреса назначения (для HTTP-прокси - с методом: "CONNECT host:port", "GET host:port")
+}
+
+// Addr возвращает адрес прокси
+func (p *testProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Targets возвращает адреса, к которым подключались через прокси
+func (p *testProxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.targets...)
+}
+
+func (p *testProxy) record(target string) {
+	p.mu.Lock()
+	p.targets = append(p.targets, target)
+	p.mu.Unlock()
+}
+
+// newTestSOCKS5Proxy запускает SOCKS5-прокси без аутентификации, поддерживающий только команду CONNECT
+func newTestSOCKS5Proxy(t *testing.T) *testProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &testProxy{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serveSOCKS5(conn)
+		}
+	}()
+	return proxy
+}
+
+// serveSOCKS5 обрабатывает одно соединение SOCKS5 (RFC 1928)
+func (p *testProxy) serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// Приветствие: версия, количество методов, методы. Отвечаем "без аутентификации"
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil || header[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(reader, make([]byte, header[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return
+	}
+
+	// Запрос: версия, команда, резерв, тип адреса, адрес, порт
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil || request[1] != 1 {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return
+		}
+		host = net.IP(addr).String()
+	case 3:
+		size, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, size)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return
+		}
+		host = string(name)
+	case 4:
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(reader, addr); err != nil {
+			return
+		}
+		host = net.IP(addr).String()
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return
+	}
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+	p.record(target)
+
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return
+	}
+	pipeConns(reader, conn, upstream)
+}
+
+// newTestHTTPProxy запускает HTTP-прокси: CONNECT открывает туннель, остальные запросы пересылаются на сервер
+func newTestHTTPProxy(t *testing.T) *testProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &testProxy{listener: listener}
+	server := &http.Server{Handler: http.HandlerFunc(proxy.serveHTTP)}
+	t.Cleanup(func() { server.Close() })
+	go server.Serve(listener)
+	return proxy
+}
+
+// serveHTTP обрабатывает запрос к HTTP-прокси
+func (p *testProxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p.record(r.Method + " " + r.Host)
+
+	if r.Method == http.MethodConnect {
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer upstream.Close()
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+			return
+		}
+		pipeConns(buffered, conn, upstream)
+		return
+	}
+
+	// Запрос в абсолютной форме (GET http://host/path) пересылаем на сервер
+	request := r.Clone(r.Context())
+	request.RequestURI = ""
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer response.Body.Close()
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+}
+
+// pipeConns копирует данные между клиентом и сервером, пока одна из сторон не закроет соединение
+func pipeConns(client io.Reader, clientConn, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, client)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// newTestFetcher создаёт fetcher без кеша и повторных попыток
+func newTestFetcher(t *testing.T, proxy *ProxyOption) *fetcher {
+	f := &fetcher{
+		options: DownloadOptions{ConnectTimeout: 5 * time.Second, Timeout: 10 * time.Second},
+		limiter: newHostLimiter(0),
+		proxies: newProxyPool(),
+		proxy:   proxy,
+	}
+	t.Cleanup(f.proxies.Close)
+	return f
+}
+
+// closedAddress возвращает адрес, на котором никто не принимает соединения
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// checkDownloaded проверяет, что источник скачан и из него получен домен сервера
+func checkDownloaded(t *testing.T, result sourceResult) {
+	t.Helper()
+	if result.err != nil {
+		t.Fatalf("unexpected error: %v", result.err)
+	}
+	list := result.list(listKey{Category: "test"})
+	if list == nil || len(list.domains.items) != 1 || list.domains.items[0] != "example.com" {
+		t.Fatalf("expected the domain 'example.com', got %+v", result.lists)
+	}
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "example.com")
+	}))
+	defer server.Close()
+	serverAddr := server.Listener.Addr().String()
+
+	// Прокси из переменных окружения не должен мешать проверке
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+
+	socks := newTestSOCKS5Proxy(t)
+	httpProxy := newTestHTTPProxy(t)
+	retries := 0
+
+	// Outbound sing-box, запускаемый внутри программы, подключается к тому же SOCKS5-прокси
+	_, socksPort, _ := net.SplitHostPort(socks.Addr())
+	outbound, err := parseProxyOption(`{"type": "socks", "server": "127.0.0.1", "server_port": ` + socksPort + `}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		global      *ProxyOption // Глобальный прокси
+		source      *ProxyOption // Прокси источника
+		socksTarget []string     // Ожидаемые подключения через SOCKS5-прокси
+		httpTarget  []string     // Ожидаемые запросы через HTTP-прокси
+	}{
+		{name: "socks5", source: &ProxyOption{URL: "socks5://" + socks.Addr()}, socksTarget: []string{serverAddr}},
+		{name: "http", source: &ProxyOption{URL: "http://" + httpProxy.Addr()}, httpTarget: []string{"GET " + serverAddr}},
+		{name: "sing-box outbound", source: outbound, socksTarget: []string{serverAddr}},
+		{name: "global socks5", global: &ProxyOption{URL: "socks5://" + socks.Addr()}, socksTarget: []string{serverAddr}},
+		{name: "direct overrides global", global: &ProxyOption{URL: "socks5://" + socks.Addr()}, source: &ProxyOption{URL: proxyDirect}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			socksBefore, httpBefore := len(socks.Targets()), len(httpProxy.Targets())
+			source := Source{URL: server.URL + "/list.txt", Category: "test", ContentType: DefaultList, Proxy: test.source, Retries: &retries}
+			checkDownloaded(t, processSource(source, newTestFetcher(t, test.global)))
+
+			if got := socks.Targets()[socksBefore:]; fmt.Sprint(got) != fmt.Sprint(test.socksTarget) {
+				t.Errorf("SOCKS5 proxy connections: expected %v, got %v", test.socksTarget, got)
+			}
+			if got := httpProxy.Targets()[httpBefore:]; fmt.Sprint(got) != fmt.Sprint(test.httpTarget) {
+				t.Errorf("HTTP proxy requests: expected %v, got %v", test.httpTarget, got)
+			}
+		})
+	}
+}
+
+func TestDownloadThroughHTTPProxyConnect(t *testing.T) {
+	// Доверенные сертификаты берутся из SSL_CERT_FILE только на Unix-подобных системах
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		t.Skip("SSL_CERT_FILE is not used on " + runtime.GOOS)
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "example.com")
+	}))
+	defer server.Close()
+
+	// Сертификат тестового сервера делаем доверенным для клиента скачивания
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", certFile)
+	t.Setenv("SSL_CERT_DIR", t.TempDir())
+
+	httpProxy := newTestHTTPProxy(t)
+	retries := 0
+	source := Source{URL: server.URL + "/list.txt", Category: "test", ContentType: DefaultList, Proxy: &ProxyOption{URL: "http://" + httpProxy.Addr()}, Retries: &retries}
+	checkDownloaded(t, processSource(source, newTestFetcher(t, nil)))
+
+	expected := []string{"CONNECT " + server.Listener.Addr().String()}
+	if got := httpProxy.Targets(); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("HTTP proxy requests: expected %v, got %v", expected, got)
+	}
+}
+
+func TestDownloadThroughRefusingProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "example.com")
+	}))
+	defer server.Close()
+
+	retries := 0
+	for _, scheme := range []string{"socks5", "http"} {
+		t.Run(scheme, func(t *testing.T) {
+			proxy := &ProxyOption{URL: scheme + "://" + closedAddress(t)}
+			source := Source{URL: server.URL + "/list.txt", Category: "test", ContentType: DefaultList, Proxy: proxy, Retries: &retries}
+			result := processSource(source, newTestFetcher(t, nil))
+			if result.err == nil {
+				t.Fatalf("expected an error for a proxy that refuses connections, got lists %+v", result.lists)
+			}
+			t.Logf("source error: %v", result.err)
+		})
+	}
+}
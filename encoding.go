@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	xunicode "golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// encodingAuto кодировка, которая определяется по BOM или содержимому файла
+const encodingAuto = "auto"
+
+// encodingSampleSize количество байт в начале файла, по которым определяется кодировка
+const encodingSampleSize = 64 * 1024
+
+// binaryContentTypes типы, которые разбирают двоичные форматы (MMDB, MRT, protobuf, .srs) и сами
+// определяют формат файла, поэтому перекодирование к ним не применяется
+var binaryContentTypes = map[ContentType]bool{
+	SingBoxRuleSet: true,
+	V2rayDat:       true,
+	CountryIPs:     true,
+	BgpTable:       true,
+}
+
+// defaultEncodings кодировки по умолчанию для типов, у которых источник всегда в одной кодировке
+var defaultEncodings = map[ContentType]string{
+	CsvDumpAntizapret: "windows-1251",
+}
+
+// cyrillicEncodings кодировки, среди которых угадывается кодировка файла в режиме auto
+var cyrillicEncodings = []struct {
+	name     string
+	encoding encoding.Encoding
+}{
+	{"windows-1251", charmap.Windows1251},
+	{"koi8-r", charmap.KOI8R},
+	{"ibm866", charmap.CodePage866},
+	{"iso-8859-5", charmap.ISO8859_5},
+}
+
+// cyrillicFrequentLetters самые частые строчные буквы русского текста
+const cyrillicFrequentLetters = "оеаинтсрвлкмдпу"
+
+// lookupEncoding находит кодировку по названию: сначала среди названий HTML (WHATWG), затем среди названий IANA,
+// чтобы были доступны все кодировки golang.org/x/text
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	name = strings.TrimSpace(name)
+	if enc, err := htmlindex.Get(name); err == nil {
+		return enc, nil
+	}
+	if enc, err := ianaindex.IANA.Encoding(name); err == nil && enc != nil {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("unknown encoding '%s'", name)
+}
+
+// sourceEncoding возвращает кодировку источника с учётом кодировки по умолчанию для его типа
+// и проверяет, что кодировка известна
+func (s Source) sourceEncoding() (string, error) {
+	name := strings.ToLower(strings.TrimSpace(s.Encoding))
+	if binaryContentTypes[s.ContentType] {
+		if name != "" {
+			return "", fmt.Errorf("'encoding' is not supported for the %s content type", s.ContentType)
+		}
+		return "", nil
+	}
+	if name == "" {
+		name = defaultEncodings[s.ContentType]
+	}
+	if name != "" && name != encodingAuto {
+		if _, err := lookupEncoding(name); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
+
+// decodingReader перекодированный в UTF-8 файл источника. Считает заменённые некорректные последовательности
+type decodingReader struct {
+	reader   io.Reader
+	encoding string              // Использованная кодировка
+	counter  *replacementCounter // Декодер, считающий замены на U+FFFD
+	started  bool                // BOM в начале уже обработан
+}
+
+// Read читает перекодированные данные и убирает BOM в начале
+func (r *decodingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if !r.started && n > 0 {
+		// BOM остаётся в начале данных, если декодер его не убрал
+		r.started = true
+		if bytes.HasPrefix(p[:n], []byte("\ufeff")) {
+			n = copy(p, p[len("\ufeff"):n])
+		}
+	}
+	return n, err
+}
+
+// invalid возвращает количество некорректных последовательностей, заменённых на U+FFFD
+func (r *decodingReader) invalid() int {
+	return r.counter.invalid
+}
+
+// replacementChar U+FFFD в UTF-8, которым декодеры заменяют некорректные последовательности
+var replacementChar = []byte(string(utf8.RuneError))
+
+// replacementCounter декодер, который считает сделанные им замены на U+FFFD: символы U+FFFD на выходе,
+// кроме тех, что были записаны в самом файле (в UTF-8 и UTF-16 U+FFFD - обычный символ)
+type replacementCounter struct {
+	decoder transform.Transformer
+	literal []byte // U+FFFD в исходной кодировке (nil - кодировка не может его содержать)
+	unit    int    // Размер кодовой единицы: U+FFFD в файле может начинаться только с её границы (2 для UTF-16)
+	offset  int    // Количество обработанных байт файла
+	invalid int    // Количество замен
+}
+
+// newReplacementCounter создаёт считающий замены декодер кодировки enc
+func newReplacementCounter(enc encoding.Encoding) *replacementCounter {
+	counter := &replacementCounter{decoder: enc.NewDecoder(), literal: encodeRune(enc, utf8.RuneError), unit: 1}
+	// В кодировках с кодовыми единицами одного размера (UTF-16) совпадение посередине единицы - не символ
+	if ascii := encodeRune(enc, 'A'); counter.literal != nil && len(ascii) == len(counter.literal) {
+		counter.unit = len(ascii)
+	}
+	return counter
+}
+
+// Transform декодирует очередной блок и считает замены в нём. Декодер обрабатывает символы целиком,
+// поэтому U+FFFD из файла не может оказаться на границе блоков
+func (c *replacementCounter) Transform(dst, src []byte, atEOF bool) (int, int, error) {
+	nDst, nSrc, err := c.decoder.Transform(dst, src, atEOF)
+	replaced := bytes.Count(dst[:nDst], replacementChar)
+	if c.literal != nil {
+		consumed := src[:nSrc]
+		for i := 0; i+len(c.literal) <= len(consumed); {
+			j := bytes.Index(consumed[i:], c.literal)
+			if j < 0 {
+				break
+			}
+			if (c.offset+i+j)%c.unit == 0 {
+				replaced--
+				i += j + len(c.literal)
+			} else {
+				i += j + 1
+			}
+		}
+	}
+	if replaced > 0 {
+		c.invalid += replaced
+	}
+	c.offset += nSrc
+	return nDst, nSrc, err
+}
+
+// Reset сбрасывает состояние декодера
+func (c *replacementCounter) Reset() {
+	c.decoder.Reset()
+	c.offset = 0
+}
+
+// encodeRune возвращает символ r в кодировке enc без BOM или nil, если кодировка не может его закодировать
+func encodeRune(enc encoding.Encoding, r rune) []byte {
+	// Кодировщик может добавить BOM в начало, поэтому берём разницу между одним и двумя символами
+	one, err := enc.NewEncoder().String(string(r))
+	if err != nil {
+		return nil
+	}
+	two, err := enc.NewEncoder().String(string(r) + string(r))
+	if err != nil || len(two) <= len(one) {
+		return nil
+	}
+	return []byte(two[len(one):])
+}
+
+// newDecodingReader перекодирует файл источника в UTF-8. encodingName - название кодировки, "auto"
+// или пустая строка (UTF-8). BOM (UTF-8, UTF-16) учитывается в любом режиме, кроме явно заданной кодировки
+func newDecodingReader(input io.Reader, encodingName string) (*decodingReader, error) {
+	buffered := bufio.NewReaderSize(input, encodingSampleSize)
+
+	var enc encoding.Encoding
+	name := encodingName
+	if encodingName != "" && encodingName != encodingAuto {
+		var err error
+		if enc, err = lookupEncoding(encodingName); err != nil {
+			return nil, err
+		}
+	} else {
+		sample, _ := buffered.Peek(encodingSampleSize)
+		name, enc = detectEncoding(sample, encodingName == encodingAuto)
+	}
+
+	counter := newReplacementCounter(enc)
+	return &decodingReader{reader: transform.NewReader(buffered, counter), encoding: name, counter: counter}, nil
+}
+
+// detectEncoding определяет кодировку по BOM. Без BOM файл считается UTF-8, а в режиме auto, если начало файла
+// не является корректным UTF-8, кодировка угадывается среди кириллических
+func detectEncoding(sample []byte, auto bool) (string, encoding.Encoding) {
+	switch {
+	case bytes.HasPrefix(sample, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8", xunicode.UTF8
+	case bytes.HasPrefix(sample, []byte{0xff, 0xfe}):
+		return "utf-16le", xunicode.UTF16(xunicode.LittleEndian, xunicode.ExpectBOM)
+	case bytes.HasPrefix(sample, []byte{0xfe, 0xff}):
+		return "utf-16be", xunicode.UTF16(xunicode.BigEndian, xunicode.ExpectBOM)
+	}
+	if !auto || isValidUTF8Sample(sample) {
+		return "utf-8", xunicode.UTF8
+	}
+
+	// Выбираем кодировку, в которой текст больше всего похож на русский: больше всего частых строчных букв
+	bestName, best, bestScore := "utf-8", encoding.Encoding(xunicode.UTF8), 0
+	for _, candidate := range cyrillicEncodings {
+		decoded, err := candidate.encoding.NewDecoder().Bytes(sample)
+		if err != nil {
+			continue
+		}
+		score := 0
+		for _, r := range string(decoded) {
+			switch {
+			case strings.ContainsRune(cyrillicFrequentLetters, r):
+				score += 2
+			case unicode.Is(unicode.Cyrillic, r) && unicode.IsLower(r):
+				score++
+			}
+		}
+		if score > bestScore {
+			bestName, best, bestScore = candidate.name, candidate.encoding, score
+		}
+	}
+	return bestName, best
+}
+
+// isValidUTF8Sample проверяет начало файла на корректность UTF-8 (последний символ может быть обрезан)
+func isValidUTF8Sample(sample []byte) bool {
+	for i := 0; i < utf8.UTFMax && len(sample) > 0; i++ {
+		if utf8.Valid(sample) {
+			return true
+		}
+		sample = sample[:len(sample)-1]
+	}
+	return utf8.Valid(sample)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestDecodingReader(t *testing.T) {
+	windows1251, _ := charmap.Windows1251.NewEncoder().String("пример.рф\n")
+	tests := []struct {
+		name     string
+		input    string
+		encoding string
+		expected string
+		detected string
+		invalid  int
+	}{
+		{name: "utf-8 with BOM", input: "\ufeffa.com\n", encoding: "utf-8", expected: "a.com\n", detected: "utf-8"},
+		// U+FFFD, записанный в самом файле, заменой не считается
+		{name: "literal replacement char", input: "a\ufffdb\n", encoding: "utf-8", expected: "a\ufffdb\n", detected: "utf-8"},
+		{name: "invalid utf-8", input: "a\xffb\xfe\n", encoding: "utf-8", expected: "a\ufffdb\ufffd\n", detected: "utf-8", invalid: 2},
+		{name: "windows-1251", input: windows1251, encoding: "windows-1251", expected: "пример.рф\n", detected: "windows-1251"},
+		{name: "auto windows-1251", input: windows1251, encoding: encodingAuto, expected: "пример.рф\n", detected: "windows-1251"},
+		{name: "auto utf-8", input: "пример.рф\n", encoding: encodingAuto, expected: "пример.рф\n", detected: "utf-8"},
+		{name: "auto utf-16le BOM", input: "\xff\xfea\x00\xfd\xff\n\x00", encoding: encodingAuto, expected: "a\ufffd\n", detected: "utf-16le"},
+		// Непарный суррогат - некорректная последовательность UTF-16
+		{name: "invalid utf-16le", input: "\xff\xfea\x00\x00\xd8\n\x00", encoding: encodingAuto, expected: "a\ufffd\n", detected: "utf-16le", invalid: 1},
+		{name: "explicit koi8-r", input: "\xd0\xd2\xc9\xcd\xc5\xd2", encoding: "koi8-r", expected: "пример", detected: "koi8-r"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoder, err := newDecodingReader(strings.NewReader(test.input), test.encoding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, err := io.ReadAll(decoder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(output) != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, output)
+			}
+			if decoder.encoding != test.detected {
+				t.Errorf("expected the encoding %s, got %s", test.detected, decoder.encoding)
+			}
+			if decoder.invalid() != test.invalid {
+				t.Errorf("expected %d replacements, got %d", test.invalid, decoder.invalid())
+			}
+		})
+	}
+}
+
+func TestSourceEncoding(t *testing.T) {
+	tests := []struct {
+		source   Source
+		expected string
+		fails    bool // Ожидается ошибка
+	}{
+		{source: Source{ContentType: DefaultList}, expected: ""},
+		{source: Source{ContentType: DefaultList, Encoding: " CP1251 "}, expected: "cp1251"},
+		{source: Source{ContentType: DefaultList, Encoding: "Auto"}, expected: encodingAuto},
+		{source: Source{ContentType: CsvDumpAntizapret}, expected: "windows-1251"},
+		{source: Source{ContentType: DefaultList, Encoding: "no-such-encoding"}, fails: true},
+		{source: Source{ContentType: V2rayDat, Encoding: "utf-8"}, fails: true},
+	}
+	for _, test := range tests {
+		name, err := test.source.sourceEncoding()
+		if test.fails {
+			if err == nil {
+				t.Errorf("%+v: expected an error, got '%s'", test.source, name)
+			}
+			continue
+		}
+		if err != nil || name != test.expected {
+			t.Errorf("%+v: expected '%s', got '%s' (%v)", test.source, test.expected, name, err)
+		}
+	}
+}
@@ -17,10 +17,10 @@ import (
 
 // Rule структура для представления правил в JSON
 type Rule struct {
-	Domain       []string `json:"domain,omitempty"`
-	DomainSuffix []string `json:"domain_suffix,omitempty"`
-	// DomainKeyword []string `json:"domain_keyword"`
-	// DomainRegex   []string `json:"domain_regex"`
+	Domain        []string `json:"domain,omitempty"`
+	DomainSuffix  []string `json:"domain_suffix,omitempty"`
+	DomainKeyword []string `json:"domain_keyword,omitempty"`
+	DomainRegex   []string `json:"domain_regex,omitempty"`
 	// SourceIPCIDR  []string `json:"source_ip_cidr"`
 	IPCIDR []string `json:"ip_cidr,omitempty"`
 }
@@ -75,6 +75,9 @@ func generate(fileDataArray []FileData, config Config) error {
 		logInfo.Printf("the directory '%s' was missing, but it was created:", config.OutputDir)
 	}
 
+	// Объединяем все файлы одной категории и типа, чтобы применились все include и exclude файлы
+	fileDataArray = groupFileData(fileDataArray)
+
 	// Переменная с доменами для
 	var domainsMap = map[string][]geosite.Item{}
 
@@ -100,8 +103,8 @@ func generate(fileDataArray []FileData, config Config) error {
 		// Подготавливаем списки для rule-set
 		RuleSetDomain := []string{}
 		RuleSetDomainSuffix := []string{}
-		// RuleSetDomainKeyword:= []string{}
-		// RuleSetDomainRegex:=   []string{}
+		RuleSetDomainKeyword := []string{}
+		RuleSetDomainRegex := []string{}
 		// RuleSetSourceIPCIDR:=  []string{}
 		RuleSetIPCIDR := []string{}
 
@@ -211,8 +214,22 @@ func generate(fileDataArray []FileData, config Config) error {
 					continue
 				}
 
-				// Если домен начинается с символа "*" (Например *.domain.com)
-				if strings.HasPrefix(domain, "*") {
+				// Если запись - ключевое слово (Например keyword:google), то домен должен содержать это слово
+				if strings.HasPrefix(domain, "keyword:") {
+					domains = append(domains, geosite.Item{
+						Type:  geosite.RuleTypeDomainKeyword,
+						Value: strings.TrimPrefix(domain, "keyword:"),
+					})
+					RuleSetDomainKeyword = append(RuleSetDomainKeyword, strings.TrimPrefix(domain, "keyword:"))
+				} else if strings.HasPrefix(domain, "regexp:") {
+					// Если запись - регулярное выражение (Например regexp:^ads\d+\.example\.com$), то домен должен под него подходить
+					domains = append(domains, geosite.Item{
+						Type:  geosite.RuleTypeDomainRegex,
+						Value: strings.TrimPrefix(domain, "regexp:"),
+					})
+					RuleSetDomainRegex = append(RuleSetDomainRegex, strings.TrimPrefix(domain, "regexp:"))
+				} else if strings.HasPrefix(domain, "*") {
+					// Если домен начинается с символа "*" (Например *.domain.com)
 					// То добавляем строку, убрав * (Получится .domain.com) и задав тип, означающий что эта запись - суффикс (окончание) домена
 					// Другими словами, эта запись позволит проксировать все поддомены указанного домена
 					domains = append(domains, geosite.Item{
@@ -261,10 +278,10 @@ func generate(fileDataArray []FileData, config Config) error {
 			Version: 1,
 			Rules: []Rule{
 				{
-					Domain:       RuleSetDomain,
-					DomainSuffix: RuleSetDomainSuffix,
-					// DomainKeyword: []string{},
-					// DomainRegex:   []string{},
+					Domain:        RuleSetDomain,
+					DomainSuffix:  RuleSetDomainSuffix,
+					DomainKeyword: RuleSetDomainKeyword,
+					DomainRegex:   RuleSetDomainRegex,
 					// SourceIPCIDR:  []string{},
 					IPCIDR: RuleSetIPCIDR,
 				},
@@ -278,7 +295,8 @@ func generate(fileDataArray []FileData, config Config) error {
 
 		if config.Generate.RuleSetJSON {
 			// Сохраняем rule-set в файл
-			if len(ruleSet.Rules[0].IPCIDR) != 0 || len(ruleSet.Rules[0].Domain) != 0 || len(ruleSet.Rules[0].DomainSuffix) != 0 {
+			if len(ruleSet.Rules[0].IPCIDR) != 0 || len(ruleSet.Rules[0].Domain) != 0 || len(ruleSet.Rules[0].DomainSuffix) != 0 ||
+				len(ruleSet.Rules[0].DomainKeyword) != 0 || len(ruleSet.Rules[0].DomainRegex) != 0 {
 				if err := SaveRuleSetToFile(ruleSet, config.OutputDir+"ruleset-"+strIpOrDomain+"-"+fileData.Category+".json"); err != nil {
 					return fmt.Errorf("error while saving rule-set: %v", err)
 				}
@@ -349,7 +367,8 @@ func generate(fileDataArray []FileData, config Config) error {
 	return nil
 }
 
-// findFileData вызвращает те FileData, у которых параметры равны isInclude, isIP, isRegexp, category
+// findFileData вызвращает FileData, у которого параметры равны isInclude, isIP, isRegexp, category
+// (после groupFileData такой FileData не больше одного)
 func findFileData(files []FileData, isInclude, isIP, isRegexp bool, category string) *FileData {
 	for _, fileData := range files {
 		if fileData.IsInclude == isInclude &&
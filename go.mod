@@ -2,7 +2,14 @@ module github.com/Dunamis4tw/generate-geoip-geosite
 
 go 1.21.4
 
-require github.com/maxmind/mmdbwriter v1.0.0
+require (
+	github.com/maxmind/mmdbwriter v1.0.0
+	github.com/oschwald/maxminddb-golang v1.12.0
+	github.com/sagernet/sing v0.2.18-0.20231201060417-575186ed63c2
+	go4.org/netipx v0.0.0-20231129151722-fdeea329fbba
+	golang.org/x/crypto v0.16.0
+	golang.org/x/text v0.14.0
+)
 
 require (
 	berty.tech/go-libtor v1.0.385 // indirect
@@ -26,7 +33,6 @@ require (
 	github.com/hashicorp/yamux v0.1.1 // indirect
 	github.com/insomniacslk/dhcp v0.0.0-20231126010706-b0416c0f187a // indirect
 	github.com/josharian/native v1.1.0 // indirect
-	github.com/klauspost/compress v1.17.4 // indirect
 	github.com/klauspost/cpuid/v2 v2.2.6 // indirect
 	github.com/libdns/alidns v1.0.3 // indirect
 	github.com/libdns/cloudflare v0.1.0 // indirect
@@ -46,7 +52,6 @@ require (
 	github.com/sagernet/netlink v0.0.0-20220905062125-8043b4a9aa97 // indirect
 	github.com/sagernet/quic-go v0.40.0 // indirect
 	github.com/sagernet/reality v0.0.0-20230406110435-ee17307e7691 // indirect
-	github.com/sagernet/sing v0.2.18-0.20231201060417-575186ed63c2 // indirect
 	github.com/sagernet/sing-dns v0.1.11 // indirect
 	github.com/sagernet/sing-mux v0.1.5-0.20231109075101-6b086ed6bb07 // indirect
 	github.com/sagernet/sing-quic v0.1.5-0.20231123150216-00957d136203 // indirect
@@ -66,11 +71,9 @@ require (
 	github.com/zeebo/blake3 v0.2.3 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
-	golang.org/x/crypto v0.16.0 // indirect
 	golang.org/x/exp v0.0.0-20231127185646-65229373498e // indirect
 	golang.org/x/mod v0.14.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	golang.org/x/tools v0.16.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231127180814-3a041ad873d4 // indirect
@@ -81,8 +84,8 @@ require (
 
 require (
 	github.com/google/uuid v1.4.0
-	github.com/oschwald/maxminddb-golang v1.12.0 // indirect
+	github.com/klauspost/compress v1.17.4
 	github.com/sagernet/sing-box v1.8.0-alpha.10
-	go4.org/netipx v0.0.0-20231129151722-fdeea329fbba // indirect
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/sys v0.15.0 // indirect
 )
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxRetryDelay ограничивает рост задержки между повторными попытками
+const maxRetryDelay = time.Minute
+
+// statusError ошибка, возвращаемая при неуспешном коде HTTP-ответа
+type statusError struct {
+	Status     string
+	StatusCode int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP response error: %s", e.Status)
+}
+
+// newHTTPClient создаёт HTTP-клиент с таймаутами соединения и ожидания заголовков ответа
+func newHTTPClient(options DownloadOptions) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   options.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   options.ConnectTimeout,
+		ResponseHeaderTimeout: options.ReadTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{Transport: transport}
+}
+
+// isRetryable сообщает, имеет ли смысл повторить запрос после ошибки err
+func isRetryable(err error) bool {
+	if statusErr, ok := err.(*statusError); ok {
+		return statusErr.StatusCode >= 500
+	}
+	// Превышение размера не исправится повторной попыткой
+	var sizeErr *sizeLimitError
+	if errors.As(err, &sizeErr) {
+		return false
+	}
+	// Все остальные ошибки - сетевые (обрыв соединения, таймаут и т.п.)
+	return true
+}
+
+// retryDelay вычисляет задержку перед попыткой attempt (начиная с 1): экспоненциальный рост и случайный разброс до 50%
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// sleepContext ждёт delay или отмены контекста ctx
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// idleTimeoutReader прерывает чтение, если данные не поступали дольше timeout
+type idleTimeoutReader struct {
+	reader  io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	mu      sync.Mutex
+	expired bool
+}
+
+// newIdleTimeoutReader оборачивает reader; по истечении timeout без данных вызывается cancel
+func newIdleTimeoutReader(reader io.Reader, timeout time.Duration, cancel context.CancelFunc) *idleTimeoutReader {
+	r := &idleTimeoutReader{reader: reader, timeout: timeout}
+	r.timer = time.AfterFunc(timeout, func() {
+		r.mu.Lock()
+		r.expired = true
+		r.mu.Unlock()
+		cancel()
+	})
+	return r
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.mu.Lock()
+	expired := r.expired
+	r.mu.Unlock()
+	if expired {
+		return n, fmt.Errorf("no data received for %s", r.timeout)
+	}
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// Stop останавливает таймер ожидания данных
+func (r *idleTimeoutReader) Stop() {
+	r.timer.Stop()
+}
+
+// sizeLimitError ошибка, возвращаемая при превышении максимального размера файла
+type sizeLimitError struct {
+	Limit int64
+}
+
+func (e *sizeLimitError) Error() string {
+	return fmt.Sprintf("the size exceeds the limit of %s", ByteSize(e.Limit))
+}
+
+// sizeLimitReader возвращает ошибку, если из reader прочитано больше limit байт
+type sizeLimitReader struct {
+	reader io.Reader
+	limit  int64
+	read   int64
+}
+
+// newSizeLimitReader ограничивает размер читаемых данных. limit <= 0 отключает ограничение
+func newSizeLimitReader(reader io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return reader
+	}
+	return &sizeLimitReader{reader: reader, limit: limit}
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		return n, &sizeLimitError{Limit: r.limit}
+	}
+	return n, err
+}
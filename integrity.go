@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// MinisignOptions параметры проверки подписи minisign
+type MinisignOptions struct {
+	PublicKey    string `json:"publicKey"`    // Публичный ключ (строка base64 или содержимое .pub файла)
+	SignatureURL string `json:"signatureUrl"` // Ссылка или путь к подписи. Default: url + ".minisig"
+}
+
+// Ed25519Options параметры проверки отсоединённой подписи ed25519
+type Ed25519Options struct {
+	PublicKey    string `json:"publicKey"`    // Публичный ключ (32 байта в base64 или hex)
+	SignatureURL string `json:"signatureUrl"` // Ссылка или путь к подписи (64 байта: бинарные, base64 или hex). Default: url + ".sig"
+}
+
+// rgxSHA256 находит sha256-хеш в опубликованном .sha256 файле
+var rgxSHA256 = regexp.MustCompile(`(?i)\b[0-9a-f]{64}\b`)
+
+// hasIntegrityChecks проверяет, задана ли для источника хотя бы одна проверка целостности
+func (s Source) hasIntegrityChecks() bool {
+	return s.SHA256 != "" || s.SHA256URL != "" || s.Minisign != nil || s.Ed25519 != nil
+}
+
+// verifyIntegrity проверяет хеши и подписи файлов источника до их распаковки и парсинга
+func (f *fetcher) verifyIntegrity(source Source, documents []sourceDocument) error {
+	if !source.hasIntegrityChecks() {
+		return nil
+	}
+	// Хеш и подпись относятся к одному файлу, а шаблон или директория могут дать несколько
+	if len(documents) != 1 {
+		return fmt.Errorf("integrity checks require exactly one file, but the source has %d", len(documents))
+	}
+	filePath := documents[0].Path
+
+	// Сверяем с закреплённым хешем
+	if source.SHA256 != "" {
+		if err := verifySHA256(filePath, source.SHA256); err != nil {
+			return err
+		}
+		logInfo.Printf("sha256 of '%s' matches the pinned value", source.URL)
+	}
+
+	// Сверяем с опубликованным хешем
+	if source.SHA256URL != "" {
+		published, err := f.fetchFile(source, source.SHA256URL)
+		if err != nil {
+			return fmt.Errorf("cannot fetch sha256 file '%s': %v", source.SHA256URL, err)
+		}
+		expected, err := findSHA256(published, source.URL)
+		if err != nil {
+			return fmt.Errorf("sha256 file '%s': %v", source.SHA256URL, err)
+		}
+		if err := verifySHA256(filePath, expected); err != nil {
+			return err
+		}
+		logInfo.Printf("sha256 of '%s' matches '%s'", source.URL, source.SHA256URL)
+	}
+
+	// Проверяем подпись minisign
+	if source.Minisign != nil {
+		signatureURL := source.Minisign.SignatureURL
+		if signatureURL == "" {
+			signatureURL = source.URL + ".minisig"
+		}
+		signature, err := f.fetchFile(source, signatureURL)
+		if err != nil {
+			return fmt.Errorf("cannot fetch minisign signature '%s': %v", signatureURL, err)
+		}
+		if err := verifyMinisign(filePath, source.Minisign.PublicKey, signature); err != nil {
+			return fmt.Errorf("minisign verification failed: %v", err)
+		}
+		logInfo.Printf("minisign signature of '%s' is valid", source.URL)
+	}
+
+	// Проверяем подпись ed25519
+	if source.Ed25519 != nil {
+		signatureURL := source.Ed25519.SignatureURL
+		if signatureURL == "" {
+			signatureURL = source.URL + ".sig"
+		}
+		signature, err := f.fetchFile(source, signatureURL)
+		if err != nil {
+			return fmt.Errorf("cannot fetch ed25519 signature '%s': %v", signatureURL, err)
+		}
+		if err := verifyEd25519(filePath, source.Ed25519.PublicKey, signature); err != nil {
+			return fmt.Errorf("ed25519 verification failed: %v", err)
+		}
+		logInfo.Printf("ed25519 signature of '%s' is valid", source.URL)
+	}
+
+	return nil
+}
+
+// fetchFile скачивает (с параметрами источника, учётные данные - только для его хоста) или читает с диска
+// вспомогательный файл: хеш или подпись
+func (f *fetcher) fetchFile(source Source, location string) ([]byte, error) {
+	if !isRemoteSource(location) {
+		filePath, err := localSourcePath(location)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(filePath)
+	}
+
+	related := source.relatedSource(location)
+	request, err := related.RequestOptions()
+	if err != nil {
+		return nil, err
+	}
+	options := related.DownloadOptions(f.options)
+	client, err := f.newClient(related, options)
+	if err != nil {
+		return nil, err
+	}
+	defer client.CloseIdleConnections()
+
+	// Вспомогательные файлы маленькие и не кешируются, чтобы подпись всегда была актуальной
+	document, _, err := downloadURL(client, location, nil, options, request)
+	if err != nil {
+		return nil, err
+	}
+	defer document.cleanup()
+	return os.ReadFile(document.Path)
+}
+
+// hashFile потоково вычисляет хеш файла
+func hashFile(filePath string, hasher hash.Hash) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
+// verifySHA256 сравнивает sha256 файла с ожидаемым hex-значением
+func verifySHA256(filePath string, expected string) error {
+	sum, err := hashFile(filePath, sha256.New())
+	if err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(sum)
+	if !strings.EqualFold(actual, strings.TrimSpace(expected)) {
+		return fmt.Errorf("sha256 mismatch: expected %s, got %s", strings.ToLower(strings.TrimSpace(expected)), actual)
+	}
+	return nil
+}
+
+// findSHA256 находит хеш файла в .sha256 файле (формат sha256sum: "<hash>  <name>" или просто "<hash>").
+// Если в файле несколько хешей, выбирается строка с именем скачиваемого файла, а без такой строки - ошибка
+func findSHA256(published []byte, sourceURL string) (string, error) {
+	name := path.Base(sourceURL)
+	if i := strings.IndexAny(name, "?#"); i >= 0 {
+		name = name[:i]
+	}
+
+	var hashes []string
+	for _, line := range strings.Split(string(published), "\n") {
+		hash := rgxSHA256.FindString(line)
+		if hash == "" {
+			continue
+		}
+		hashes = append(hashes, hash)
+		fields := strings.Fields(strings.Replace(line, hash, "", 1))
+		if len(fields) > 0 && strings.TrimPrefix(fields[len(fields)-1], "*") == name {
+			return hash, nil
+		}
+	}
+	switch len(hashes) {
+	case 0:
+		return "", fmt.Errorf("no sha256 hash found")
+	case 1:
+		// Единственный хеш относится к скачиваемому файлу, даже если имя в файле другое или не указано
+		return hashes[0], nil
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// decodeKey декодирует ключ или подпись из base64 или hex
+func decodeKey(value string, size int) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if decoded, err := hex.DecodeString(value); err == nil && len(decoded) == size {
+		return decoded, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) == size {
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("expected %d bytes in base64 or hex", size)
+}
+
+// verifyEd25519 проверяет отсоединённую подпись ed25519. Ed25519 подписывает сообщение целиком,
+// поэтому файл читается в память
+func verifyEd25519(filePath string, publicKey string, signature []byte) error {
+	key, err := decodeKey(publicKey, ed25519.PublicKeySize)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	// Подпись может быть бинарной или текстовой (base64/hex)
+	if len(signature) != ed25519.SignatureSize {
+		signature, err = decodeKey(string(signature), ed25519.SignatureSize)
+		if err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// lastNonCommentLine возвращает последнюю непустую строку, не являющуюся комментарием minisign
+func lastNonCommentLine(text string) string {
+	var result string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "untrusted comment:") {
+			result = line
+		}
+	}
+	return result
+}
+
+// verifyMinisign проверяет подпись в формате minisign (обычную "Ed" и с предхешированием "ED"),
+// включая глобальную подпись доверенного комментария
+func verifyMinisign(filePath string, publicKey string, signatureFile []byte) error {
+	// Публичный ключ: алгоритм (2 байта), идентификатор ключа (8 байт), ключ (32 байта)
+	keyData, err := base64.StdEncoding.DecodeString(lastNonCommentLine(publicKey))
+	if err != nil || len(keyData) != 42 || string(keyData[:2]) != "Ed" {
+		return fmt.Errorf("invalid minisign public key")
+	}
+	keyID, key := keyData[2:10], ed25519.PublicKey(keyData[10:])
+
+	// Файл подписи: недоверенный комментарий, подпись, доверенный комментарий, глобальная подпись
+	var lines []string
+	for _, line := range strings.Split(string(signatureFile), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("invalid minisign signature file")
+	}
+	signature, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(signature) != 74 {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	globalSignature, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(globalSignature) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign global signature")
+	}
+	if !bytes.Equal(signature[2:10], keyID) {
+		return fmt.Errorf("the signature was made with a different key (key id %X, expected %X)", reverseBytes(signature[2:10]), reverseBytes(keyID))
+	}
+
+	// Для алгоритма "ED" подписывается хеш BLAKE2b-512 файла, его можно посчитать потоково
+	var message []byte
+	switch string(signature[:2]) {
+	case "Ed":
+		message, err = os.ReadFile(filePath)
+	case "ED":
+		hasher, _ := blake2b.New512(nil)
+		message, err = hashFile(filePath, hasher)
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm '%s'", signature[:2])
+	}
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, message, signature[10:]) {
+		return fmt.Errorf("signature does not match")
+	}
+
+	// Глобальная подпись защищает доверенный комментарий
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(key, append(append([]byte{}, signature[10:]...), trustedComment...), globalSignature) {
+		return fmt.Errorf("trusted comment signature does not match")
+	}
+	return nil
+}
+
+// reverseBytes возвращает байты в обратном порядке (minisign показывает идентификатор ключа в little-endian)
+func reverseBytes(data []byte) []byte {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFindSHA256(t *testing.T) {
+	const (
+		hashA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		hashB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	)
+	tests := []struct {
+		name      string
+		published string
+		expected  string // Пустая строка - ожидается ошибка
+	}{
+		{name: "single hash", published: hashA + "\n", expected: hashA},
+		{name: "single hash with another name", published: hashA + "  other.txt\n", expected: hashA},
+		{name: "matching line", published: hashA + "  other.txt\n" + hashB + " *list.txt\n", expected: hashB},
+		{name: "no matching line", published: hashA + "  other.txt\n" + hashB + "  another.txt\n"},
+		{name: "no hash", published: "not a checksum file\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := findSHA256([]byte(test.published), "https://example.com/lists/list.txt?raw=1")
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("expected an error, got %s", hash)
+				}
+				return
+			}
+			if err != nil || hash != test.expected {
+				t.Fatalf("expected %s, got %s (%v)", test.expected, hash, err)
+			}
+		})
+	}
+}
+
+func TestRejectedFileIsNotCached(t *testing.T) {
+	const body = "example.com\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	cache, err := newDownloadCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newTestFetcher(t, &ProxyOption{URL: proxyDirect})
+	f.cache = cache
+
+	retries := 0
+	source := Source{URL: server.URL + "/list.txt", Category: "test", ContentType: DefaultList, Retries: &retries}
+
+	// Файл с неверным хешем отклоняется и не попадает в кеш
+	source.SHA256 = hex.EncodeToString(make([]byte, sha256.Size))
+	for attempt := 1; attempt <= 2; attempt++ {
+		if result := processSource(source, f); result.err == nil {
+			t.Fatalf("attempt %d: expected an integrity error", attempt)
+		}
+		if meta, _ := cache.load(source.URL); meta != nil {
+			t.Fatalf("attempt %d: the rejected file was cached", attempt)
+		}
+	}
+
+	// Проверенный файл сохраняется в кеш и затем берётся из него по ответу 304
+	sum := sha256.Sum256([]byte(body))
+	source.SHA256 = hex.EncodeToString(sum[:])
+	checkDownloaded(t, processSource(source, f))
+	meta, bodyPath := cache.load(source.URL)
+	if meta == nil {
+		t.Fatal("the verified file was not cached")
+	}
+	if _, err := os.Stat(bodyPath); err != nil {
+		t.Fatal(err)
+	}
+	result := processSource(source, f)
+	checkDownloaded(t, result)
+	if result.changed {
+		t.Error("expected the cached copy to be used")
+	}
+}
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JsonOptions выражения JSONPath для типа Json
+type JsonOptions struct {
+	Domains []string `json:"domains"` // Выражения, которые выбирают домены (Например: "$[*].domains[*]")
+	IPs     []string `json:"ips"`     // Выражения, которые выбирают IP-адреса и сети (Например: "$.data.items[?(@.active)].cidr")
+}
+
+// Виды шагов выражения JSONPath
+const (
+	jsonPathName     = iota // .name, ['name']
+	jsonPathIndex           // [0], [-1]
+	jsonPathSlice           // [1:3], [:2], [-2:]
+	jsonPathWildcard        // .*, [*]
+	jsonPathFilter          // [?(@.active)]
+)
+
+// jsonPathStep шаг выражения JSONPath
+type jsonPathStep struct {
+	kind      int
+	name      string
+	index     int  // Индекс или начало среза
+	end       int  // Конец среза (не включая)
+	hasEnd    bool // Конец среза задан
+	filter    jsonPathExpr
+	recursive bool // Шаг после ".." применяется к узлу и всем его потомкам
+}
+
+// jsonPath скомпилированное выражение JSONPath
+type jsonPath struct {
+	steps []jsonPathStep
+}
+
+// compileJSONPath разбирает выражение JSONPath. Поддерживаются $, .name, ['name'], [n], [start:end], .*, [*], ..name, ..*
+// и фильтры [?(...)] со сравнениями (==, !=, <, <=, >, >=), !, && и ||
+func compileJSONPath(expr string) (*jsonPath, error) {
+	rest := strings.TrimSpace(expr)
+	if !strings.HasPrefix(rest, "$") {
+		return nil, fmt.Errorf("invalid JSONPath '%s': it must start with '$'", expr)
+	}
+	steps, err := parseJSONPathSteps(rest[1:], true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSONPath '%s': %v", expr, err)
+	}
+	return &jsonPath{steps: steps}, nil
+}
+
+// parseJSONPathSteps разбирает шаги выражения. В относительных путях фильтров (@.a.b) wildcard и фильтры
+// не используются, allowAll = false
+func parseJSONPathSteps(rest string, allowAll bool) ([]jsonPathStep, error) {
+	var steps []jsonPathStep
+	for rest != "" {
+		var step jsonPathStep
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			step.recursive = true
+			rest = rest[2:]
+			if strings.HasPrefix(rest, "[") {
+				break
+			}
+			fallthrough
+		case strings.HasPrefix(rest, "."):
+			rest = strings.TrimPrefix(rest, ".")
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			if name == "" {
+				return nil, fmt.Errorf("empty name")
+			}
+			if strings.ContainsAny(name, "]()'\"") {
+				return nil, fmt.Errorf("unexpected '%s'", name)
+			}
+			if name == "*" {
+				step.kind = jsonPathWildcard
+			} else {
+				step.kind, step.name = jsonPathName, name
+			}
+			steps = append(steps, step)
+			continue
+		case !strings.HasPrefix(rest, "["):
+			return nil, fmt.Errorf("unexpected '%s'", rest)
+		}
+
+		// Шаг в скобках
+		end := findJSONPathBracket(rest)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed '['")
+		}
+		content := strings.TrimSpace(rest[1:end])
+		rest = rest[end+1:]
+
+		switch {
+		case content == "*":
+			step.kind = jsonPathWildcard
+		case strings.HasPrefix(content, "?"):
+			filter, err := compileJSONPathFilter(content[1:])
+			if err != nil {
+				return nil, err
+			}
+			step.kind, step.filter = jsonPathFilter, filter
+		case len(content) >= 2 && (content[0] == '\'' || content[0] == '"'):
+			name, err := unquoteJSONPathString(content)
+			if err != nil {
+				return nil, err
+			}
+			step.kind, step.name = jsonPathName, name
+		case strings.Contains(content, ":"):
+			if err := parseJSONPathSlice(content, &step); err != nil {
+				return nil, err
+			}
+		default:
+			index, err := strconv.Atoi(content)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported selector '[%s]'", content)
+			}
+			step.kind, step.index = jsonPathIndex, index
+		}
+		steps = append(steps, step)
+	}
+
+	if !allowAll {
+		for _, step := range steps {
+			if step.kind == jsonPathWildcard || step.kind == jsonPathSlice || step.kind == jsonPathFilter || step.recursive {
+				return nil, fmt.Errorf("only names and indices are supported in filter paths")
+			}
+		}
+	}
+	return steps, nil
+}
+
+// parseJSONPathSlice разбирает срез "start:end". Границы можно опустить, отрицательные отсчитываются с конца
+func parseJSONPathSlice(content string, step *jsonPathStep) error {
+	start, end, _ := strings.Cut(content, ":")
+	start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+	step.kind = jsonPathSlice
+	if start != "" {
+		index, err := strconv.Atoi(start)
+		if err != nil {
+			return fmt.Errorf("unsupported selector '[%s]'", content)
+		}
+		step.index = index
+	}
+	if end != "" {
+		index, err := strconv.Atoi(end)
+		if err != nil {
+			return fmt.Errorf("unsupported selector '[%s]'", content)
+		}
+		step.end, step.hasEnd = index, true
+	}
+	return nil
+}
+
+// findJSONPathBracket находит закрывающую скобку шага с учётом кавычек и вложенных скобок
+func findJSONPathBracket(text string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '[' || c == '(':
+			depth++
+		case c == ']' || c == ')':
+			depth--
+			if depth == 0 && c == ']' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// unquoteJSONPathString снимает одинарные или двойные кавычки со строки
+func unquoteJSONPathString(text string) (string, error) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("invalid string %s", text)
+	}
+	if text[0] == '\'' {
+		text = `"` + strings.ReplaceAll(strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(text)
+}
+
+// jsonPathExpr условие фильтра: проверяет элемент (@)
+type jsonPathExpr func(node interface{}) bool
+
+// compileJSONPathFilter разбирает условие фильтра "(...)": операнды "||", затем "&&", затем сравнения
+func compileJSONPathFilter(text string) (jsonPathExpr, error) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "(") || !strings.HasSuffix(text, ")") {
+		return nil, fmt.Errorf("filter must be written as [?(...)]")
+	}
+	return compileJSONPathOr(text[1 : len(text)-1])
+}
+
+// compileJSONPathOr разбирает условия, объединённые "||"
+func compileJSONPathOr(text string) (jsonPathExpr, error) {
+	var operands []jsonPathExpr
+	for _, part := range splitJSONPathFilter(text, "||") {
+		operand, err := compileJSONPathAnd(part)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	return func(node interface{}) bool {
+		for _, operand := range operands {
+			if operand(node) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// compileJSONPathAnd разбирает условия, объединённые "&&"
+func compileJSONPathAnd(text string) (jsonPathExpr, error) {
+	var operands []jsonPathExpr
+	for _, part := range splitJSONPathFilter(text, "&&") {
+		operand, err := compileJSONPathComparison(part)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+	}
+	return func(node interface{}) bool {
+		for _, operand := range operands {
+			if !operand(node) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// splitJSONPathFilter разбивает условие по оператору вне кавычек и скобок
+func splitJSONPathFilter(text, operator string) []string {
+	var parts []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(text[i:], operator):
+			parts = append(parts, text[start:i])
+			start = i + len(operator)
+			i += len(operator) - 1
+		}
+	}
+	return append(parts, text[start:])
+}
+
+// jsonPathOperators операторы сравнения (двухсимвольные проверяются раньше односимвольных)
+var jsonPathOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// compileJSONPathComparison разбирает сравнение "@.path op value", проверку "@.path" или "!@.path"
+func compileJSONPathComparison(text string) (jsonPathExpr, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		return compileJSONPathOr(text[1 : len(text)-1])
+	}
+	if strings.HasPrefix(text, "!") && !strings.HasPrefix(text, "!=") {
+		operand, err := compileJSONPathComparison(text[1:])
+		if err != nil {
+			return nil, err
+		}
+		return func(node interface{}) bool { return !operand(node) }, nil
+	}
+
+	// Ищем оператор вне кавычек
+	left, operator, right := text, "", ""
+	for i := 0; i < len(text) && operator == ""; i++ {
+		if text[i] == '\'' || text[i] == '"' {
+			break
+		}
+		for _, op := range jsonPathOperators {
+			if strings.HasPrefix(text[i:], op) {
+				left, operator, right = strings.TrimSpace(text[:i]), op, strings.TrimSpace(text[i+len(op):])
+				break
+			}
+		}
+	}
+
+	if !strings.HasPrefix(left, "@") {
+		return nil, fmt.Errorf("filter operand must start with '@': '%s'", left)
+	}
+	steps, err := parseJSONPathSteps(left[1:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Проверка существования: значение есть и не равно false/null
+	if operator == "" {
+		return func(node interface{}) bool {
+			value, ok := jsonPathLookup(node, steps)
+			return ok && value != nil && value != false
+		}, nil
+	}
+
+	literal, err := parseJSONPathLiteral(right)
+	if err != nil {
+		return nil, err
+	}
+	return func(node interface{}) bool {
+		value, ok := jsonPathLookup(node, steps)
+		if !ok {
+			return operator == "!="
+		}
+		return compareJSONPathValues(value, operator, literal)
+	}, nil
+}
+
+// parseJSONPathLiteral разбирает значение в сравнении: строку, число, true, false или null
+func parseJSONPathLiteral(text string) (interface{}, error) {
+	switch text {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if len(text) >= 2 && (text[0] == '\'' || text[0] == '"') {
+		return unquoteJSONPathString(text)
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value '%s' in filter", text)
+	}
+	return number, nil
+}
+
+// compareJSONPathValues сравнивает значение элемента со значением из фильтра
+func compareJSONPathValues(value interface{}, operator string, literal interface{}) bool {
+	if number, ok := value.(json.Number); ok {
+		value, _ = number.Float64()
+	}
+
+	var cmp int
+	switch l := literal.(type) {
+	case float64:
+		v, ok := value.(float64)
+		if !ok {
+			return operator == "!="
+		}
+		cmp = compareOrdered(v, l)
+	case string:
+		v, ok := value.(string)
+		if !ok {
+			return operator == "!="
+		}
+		cmp = strings.Compare(v, l)
+	default:
+		// true, false и null сравниваются только на равенство
+		switch operator {
+		case "==":
+			return value == literal
+		case "!=":
+			return value != literal
+		}
+		return false
+	}
+
+	switch operator {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compareOrdered сравнивает два числа
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// jsonPathLookup находит значение по относительному пути из имён и индексов
+func jsonPathLookup(node interface{}, steps []jsonPathStep) (interface{}, bool) {
+	for _, step := range steps {
+		var ok bool
+		switch step.kind {
+		case jsonPathName:
+			var object map[string]interface{}
+			if object, ok = node.(map[string]interface{}); ok {
+				node, ok = object[step.name]
+			}
+		case jsonPathIndex:
+			var array []interface{}
+			if array, ok = node.([]interface{}); ok {
+				node, ok = jsonPathElement(array, step.index)
+			}
+		}
+		if !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// jsonPathElement возвращает элемент массива; отрицательный индекс отсчитывается с конца
+func jsonPathElement(array []interface{}, index int) (interface{}, bool) {
+	if index < 0 {
+		index += len(array)
+	}
+	if index < 0 || index >= len(array) {
+		return nil, false
+	}
+	return array[index], true
+}
+
+// jsonPathSliceElements возвращает элементы среза массива; границы за пределами массива обрезаются
+func jsonPathSliceElements(array []interface{}, step jsonPathStep) []interface{} {
+	bound := func(index int) int {
+		if index < 0 {
+			index += len(array)
+		}
+		return min(max(index, 0), len(array))
+	}
+	start, end := bound(step.index), len(array)
+	if step.hasEnd {
+		end = bound(step.end)
+	}
+	if start >= end {
+		return nil
+	}
+	return array[start:end]
+}
+
+// jsonPathChildren возвращает элементы массива или значения объекта (в порядке ключей, чтобы результат не менялся)
+func jsonPathChildren(node interface{}) []interface{} {
+	switch v := node.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		children := make([]interface{}, 0, len(v))
+		for _, key := range keys {
+			children = append(children, v[key])
+		}
+		return children
+	}
+	return nil
+}
+
+// jsonPathDescendants возвращает узел и всех его потомков
+func jsonPathDescendants(node interface{}, result []interface{}) []interface{} {
+	result = append(result, node)
+	for _, child := range jsonPathChildren(node) {
+		result = jsonPathDescendants(child, result)
+	}
+	return result
+}
+
+// evaluateJSONPath применяет шаги выражения к узлу и вызывает handle для каждого найденного значения
+func evaluateJSONPath(node interface{}, steps []jsonPathStep, handle func(value interface{})) {
+	if len(steps) == 0 {
+		handle(node)
+		return
+	}
+	step := steps[0]
+
+	nodes := []interface{}{node}
+	if step.recursive {
+		nodes = jsonPathDescendants(node, nil)
+	}
+	for _, current := range nodes {
+		switch step.kind {
+		case jsonPathName:
+			if object, ok := current.(map[string]interface{}); ok {
+				if child, ok := object[step.name]; ok {
+					evaluateJSONPath(child, steps[1:], handle)
+				}
+			}
+		case jsonPathIndex:
+			if array, ok := current.([]interface{}); ok {
+				if child, ok := jsonPathElement(array, step.index); ok {
+					evaluateJSONPath(child, steps[1:], handle)
+				}
+			}
+		case jsonPathSlice:
+			if array, ok := current.([]interface{}); ok {
+				for _, child := range jsonPathSliceElements(array, step) {
+					evaluateJSONPath(child, steps[1:], handle)
+				}
+			}
+		case jsonPathWildcard:
+			for _, child := range jsonPathChildren(current) {
+				evaluateJSONPath(child, steps[1:], handle)
+			}
+		case jsonPathFilter:
+			for _, child := range jsonPathChildren(current) {
+				if step.filter(child) {
+					evaluateJSONPath(child, steps[1:], handle)
+				}
+			}
+		}
+	}
+}
+
+// jsonExtractor выражение, которое выбирает домены или IP-адреса
+type jsonExtractor struct {
+	path *jsonPath
+	isIP bool
+}
+
+// parseJson извлекает домены и IP-адреса из JSON выражениями JSONPath из поля json источника.
+// Если все выражения начинаются с "$[*]" и файл - массив, элементы разбираются по одному, не загружая
+// весь файл в память. Найденный массив строк разворачивается в отдельные значения
+func parseJson(input io.Reader, source Source, emit func(Entry)) error {
+	if source.JSON == nil || len(source.JSON.Domains) == 0 && len(source.JSON.IPs) == 0 {
+		return fmt.Errorf("'json' with 'domains' or 'ips' expressions is required for the Json content type")
+	}
+
+	var extractors []jsonExtractor
+	streamable := true
+	for _, expressions := range []struct {
+		list []string
+		isIP bool
+	}{{source.JSON.Domains, false}, {source.JSON.IPs, true}} {
+		for _, expr := range expressions.list {
+			path, err := compileJSONPath(expr)
+			if err != nil {
+				return err
+			}
+			extractors = append(extractors, jsonExtractor{path: path, isIP: expressions.isIP})
+			if len(path.steps) == 0 || path.steps[0].kind != jsonPathWildcard || path.steps[0].recursive {
+				streamable = false
+			}
+		}
+	}
+
+	invalid := 0
+	emitValue := func(value interface{}, isIP bool) {
+		text, ok := value.(string)
+		if !ok {
+			invalid++
+			return
+		}
+		text = strings.TrimSpace(text)
+		if isIP {
+			if !isIPOrNetwork(text) {
+				invalid++
+				return
+			}
+			emit(Entry{Value: text, IsIP: true})
+			return
+		}
+		text = strings.TrimSuffix(strings.ToLower(text), ".")
+		if !rgxDomain.MatchString(text) {
+			invalid++
+			return
+		}
+		emit(Entry{Value: text})
+	}
+	extract := func(node interface{}, skipFirst int) {
+		for _, extractor := range extractors {
+			evaluateJSONPath(node, extractor.path.steps[skipFirst:], func(value interface{}) {
+				// Массив строк (например, "domains": [...]) разворачиваем
+				if array, ok := value.([]interface{}); ok {
+					for _, item := range array {
+						emitValue(item, extractor.isIP)
+					}
+					return
+				}
+				emitValue(value, extractor.isIP)
+			})
+		}
+	}
+
+	buffered := bufio.NewReader(input)
+	var err error
+	if streamable && startsWithJSONArray(buffered) {
+		err = decodeJSONArray(buffered, func(decoder *json.Decoder) error {
+			decoder.UseNumber()
+			var item interface{}
+			if err := decoder.Decode(&item); err != nil {
+				return err
+			}
+			extract(item, 1)
+			return nil
+		})
+	} else {
+		decoder := json.NewDecoder(buffered)
+		decoder.UseNumber()
+		var document interface{}
+		if err = decoder.Decode(&document); err == nil {
+			extract(document, 0)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if invalid > 0 {
+		logWarn.Printf("source '%s': skipped %d values that are not valid domains or IP addresses", source.URL, invalid)
+	}
+	return nil
+}
+
+// startsWithJSONArray проверяет, что JSON начинается с массива
+func startsWithJSONArray(reader *bufio.Reader) bool {
+	for i := 1; ; i++ {
+		data, err := reader.Peek(i)
+		if err != nil || len(data) < i {
+			return false
+		}
+		switch data[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '[':
+			return true
+		}
+		return false
+	}
+}
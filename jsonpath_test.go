@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testJSONDocument = `{
+	"data": {
+		"items": [
+			{"cidr": "10.0.0.0/8", "active": true, "domain": "a.com", "weight": 1},
+			{"cidr": "172.16.0.0/12", "active": false, "domain": "b.com", "weight": 5},
+			{"cidr": "192.168.0.0/16", "domain": "c.com", "tags": {"domain": "d.com"}}
+		],
+		"domains": ["e.com", "f.com", "g.com", "h.com"]
+	},
+	"domain": "root.com",
+	"with.dot": "i.com"
+}`
+
+func TestEvaluateJSONPath(t *testing.T) {
+	var document interface{}
+	if err := json.Unmarshal([]byte(testJSONDocument), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		// Проверка без сравнения: значение есть и не равно false или null
+		{expr: "$.data.items[?(@.active)].cidr", expected: []string{"10.0.0.0/8"}},
+		{expr: "$.data.items[?(@.tags)].domain", expected: []string{"c.com"}},
+		{expr: "$.data.items[?(@.active == true)].cidr", expected: []string{"10.0.0.0/8"}},
+		{expr: "$.data.items[?(!@.active)].domain", expected: []string{"b.com", "c.com"}},
+		{expr: "$.data.items[?(@.weight > 1 || @.domain == 'c.com')].domain", expected: []string{"b.com", "c.com"}},
+		{expr: "$.data.items[?(@.active && @.weight <= 1)].domain", expected: []string{"a.com"}},
+		{expr: "$..domain", expected: []string{"root.com", "a.com", "b.com", "c.com", "d.com"}},
+		{expr: "$.data.items[*].domain", expected: []string{"a.com", "b.com", "c.com"}},
+		{expr: "$.data.items.*.cidr", expected: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}},
+		{expr: "$.data.domains[0]", expected: []string{"e.com"}},
+		{expr: "$.data.domains[-1]", expected: []string{"h.com"}},
+		{expr: "$.data.domains[10]", expected: nil},
+		{expr: "$.data.domains[1:3]", expected: []string{"f.com", "g.com"}},
+		{expr: "$.data.domains[:2]", expected: []string{"e.com", "f.com"}},
+		{expr: "$.data.domains[-2:]", expected: []string{"g.com", "h.com"}},
+		{expr: "$.data.domains[3:1]", expected: nil},
+		{expr: "$.data.domains[2:100]", expected: []string{"g.com", "h.com"}},
+		{expr: "$.data['domains'][1]", expected: []string{"f.com"}},
+		{expr: `$["with.dot"]`, expected: []string{"i.com"}},
+		{expr: "$.missing[*]", expected: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			path, err := compileJSONPath(test.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []string
+			evaluateJSONPath(document, path.steps, func(value interface{}) {
+				values = append(values, fmt.Sprint(value))
+			})
+			if fmt.Sprint(values) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, values)
+			}
+		})
+	}
+}
+
+func TestCompileJSONPathErrors(t *testing.T) {
+	// Некорректные выражения должны давать ошибку, а не панику
+	for _, expr := range []string{
+		"",
+		"data.items",
+		"$.",
+		"$..",
+		"$.a[",
+		"$.a[]",
+		"$.a[x]",
+		"$.a[1:x]",
+		"$.a['b]",
+		"$.a[?(@.b ==)]",
+		"$.a[?()]",
+		"$.a[?(@.b == 'c]",
+		"$.a[?(@.b[*])]",
+		"$.a[?(@..b)]",
+		"$.a[?(@.b == 1 &&)]",
+		"$.a]",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := compileJSONPath(expr); err == nil {
+				t.Errorf("expected an error for '%s'", expr)
+			}
+		})
+	}
+}
+
+func TestParseJson(t *testing.T) {
+	const input = `[
+		{"domains": ["a.com", "bad_domain!"], "ip": "10.0.0.1"},
+		{"domains": "b.com", "ip": "not-an-ip"}
+	]`
+	var entries []string
+	source := Source{URL: "test", JSON: &JsonOptions{Domains: []string{"$[*].domains"}, IPs: []string{"$[*].ip"}}}
+	err := parseJson(strings.NewReader(input), source, func(entry Entry) {
+		entries = append(entries, fmt.Sprintf("%s ip=%t", entry.Value, entry.IsIP))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a.com ip=false", "10.0.0.1 ip=true", "b.com ip=false"}
+	if fmt.Sprint(entries) != fmt.Sprint(expected) {
+		t.Errorf("expected %q, got %q", expected, entries)
+	}
+}
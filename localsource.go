@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sourceDocument один файл источника: скачанный по ссылке или прочитанный с диска
+type sourceDocument struct {
+	Name     string // Ссылка или путь к файлу (для логов и определения формата по расширению)
+	Path     string // Путь к файлу с содержимым на диске
+	Encoding string // Значение заголовка Content-Encoding (только для скачанных файлов)
+	Temp     bool   // true, если Path - временный файл, который нужно удалить после обработки
+
+	cacheHeader http.Header // Заголовки ответа, с которыми файл сохраняется в кеш после проверки (nil - не сохранять)
+}
+
+// cleanup удаляет временный файл документа
+func (d sourceDocument) cleanup() {
+	if d.Temp {
+		os.Remove(d.Path)
+	}
+}
+
+// isRemoteSource проверяет, нужно ли скачивать источник по HTTP(S)
+func isRemoteSource(location string) bool {
+	lower := strings.ToLower(location)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// localSourcePath превращает ссылку file:// или обычный путь в путь файловой системы
+func localSourcePath(location string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(location), "file://") {
+		return location, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid file URL '%s': %v", location, err)
+	}
+	// file://relative/path разбирается как хост "relative" и путь "/path", собираем обратно
+	path := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		path = u.Host + path
+	}
+	return filepath.FromSlash(path), nil
+}
+
+// listLocalFiles возвращает отсортированный список файлов источника. Путь может указывать на файл,
+// на директорию (читается рекурсивно) или быть шаблоном (например: /lists/*.txt)
+func listLocalFiles(location string) ([]string, error) {
+	path, err := localSourcePath(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// Раскрываем шаблон (если это не шаблон, Glob вернёт сам путь при его наличии)
+	matches, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path pattern '%s': %v", path, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match '%s'", path)
+	}
+
+	var files []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, match)
+			continue
+		}
+		// Директорию обходим рекурсивно
+		dirFiles, err := getFilesInFolder(match)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, dirFiles...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in '%s'", path)
+	}
+
+	sort.Strings(files)
+	return uniqueSlice(files), nil
+}
+
+// readLocalSource возвращает документы для всех файлов локального источника
+func readLocalSource(location string) ([]sourceDocument, error) {
+	files, err := listLocalFiles(location)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]sourceDocument, 0, len(files))
+	for _, file := range files {
+		documents = append(documents, sourceDocument{Name: file, Path: file})
+	}
+	return documents, nil
+}
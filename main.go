@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
 )
 
+// exitPartialSuccess код завершения, если генерация прошла, но часть источников не удалось скачать (режим fail-soft)
+const exitPartialSuccess = 2
+
 var (
 	logWarn  = log.New(os.Stdout, "WARN  ", log.LstdFlags)
 	logInfo  = log.New(os.Stdout, "INFO  ", log.LstdFlags)
@@ -21,9 +25,18 @@ func main() {
 		OutputDir:  options.OutputDir,
 		SourceFile: options.SourceFile,
 		Generate:   options.Generate,
+		Workers:    options.Workers,
+		PerHost:    options.PerHost,
+		CacheDir:   options.CacheDir,
+		Download:   options.Download,
+		FailSoft:   options.FailSoft,
+		Proxy:      options.Proxy,
 		Sources:    []Source{},
 	}
 
+	// Ошибка частичного скачивания в режиме fail-soft (генерация при этом продолжается)
+	var partialErr *PartialError
+
 	// Если указан файл с источникам, то
 	if len(config.SourceFile) != 0 {
 		// Читаем источники
@@ -36,7 +49,10 @@ func main() {
 		// Скачиваем их
 		logInfo.Print("==== DOWNLOADING ====")
 		if err := Downloader(&config); err != nil {
-			logError.Fatal(err)
+			if !errors.As(err, &partialErr) {
+				logError.Fatal(err)
+			}
+			logWarn.Print(err)
 		}
 	}
 
@@ -53,4 +69,10 @@ func main() {
 	if err != nil {
 		logError.Fatal(err)
 	}
+
+	// Сообщаем кодом завершения, что генерация прошла не со всеми источниками
+	if partialErr != nil {
+		logWarn.Printf("partial success: %d of %d sources failed, their previous lists were used", partialErr.Failed, partialErr.Total)
+		os.Exit(exitPartialSuccess)
+	}
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// scanLines читает input построчно (без ограничения на длину строки) и вызывает handle для каждой строки
+func scanLines(input io.Reader, handle func(line string)) error {
+	reader := bufio.NewReaderSize(input, 64*1024)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			handle(strings.TrimRight(line, "\r\n"))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeJSONArray читает JSON-массив потоком и вызывает handle для декодирования каждого элемента
+func decodeJSONArray(input io.Reader, handle func(decoder *json.Decoder) error) error {
+	decoder := json.NewDecoder(input)
+
+	// Проверяем, что это массив
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected a JSON array")
+	}
+
+	// Декодируем элементы по одному
+	for decoder.More() {
+		if err := handle(decoder); err != nil {
+			return err
+		}
+	}
+
+	// Читаем закрывающую скобку
+	_, err = decoder.Token()
+	return err
+}
+
+func parseJsonListDomains(input io.Reader, source Source, emit func(Entry)) error {
+	return decodeJSONArray(input, func(decoder *json.Decoder) error {
+		var domain string
+		if err := decoder.Decode(&domain); err != nil {
+			return err
+		}
+		emit(Entry{Value: domain})
+		return nil
+	})
+}
+
+func parseJsonListIPs(input io.Reader, source Source, emit func(Entry)) error {
+	return decodeJSONArray(input, func(decoder *json.Decoder) error {
+		var ip string
+		if err := decoder.Decode(&ip); err != nil {
+			return err
+		}
+		emit(Entry{Value: ip, IsIP: true})
+		return nil
+	})
+}
+
+func parseJsonRublacklistDPI(input io.Reader, source Source, emit func(Entry)) error {
+	// Создаём стркутуру
+	type Data struct {
+		Domains     []string `json:"domains"`
+		Name        string   `json:"name"`
+		Restriction struct {
+			Code string `json:"code"`
+		} `json:"restriction"`
+	}
+
+	// Парсим json поэлементно и добавлям домены в общий список
+	return decodeJSONArray(input, func(decoder *json.Decoder) error {
+		var item Data
+		if err := decoder.Decode(&item); err != nil {
+			return err
+		}
+		for _, domain := range item.Domains {
+			emit(Entry{Value: domain})
+		}
+		return nil
+	})
+}
+
+// parseCsvDumpAntizapret разбирает дамп Антизапрета (файл в Windows-1251 перекодируется до парсинга, см. defaultEncodings)
+func parseCsvDumpAntizapret(input io.Reader, source Source, emit func(Entry)) error {
+	return scanLines(input, func(line string) {
+		// Разделяем строку на столбцы по символу ";"
+		columns := strings.Split(line, ";")
+
+		// Пропускаем первую строку (в ней один столбец)
+		if len(columns) == 1 {
+			return
+		}
+
+		// Извлекаем IP-адреса из первого столбца
+		for _, ip := range strings.Split(columns[0], "|") {
+			emit(Entry{Value: ip, IsIP: true})
+		}
+
+		// Если есть второй столбца, извлекаем домены из нее
+		if len(columns) > 1 {
+			for _, domain := range strings.Split(columns[1], "|") {
+				emit(Entry{Value: domain})
+			}
+		}
+	})
+}
+
+var (
+	rgxIPv4   = regexp.MustCompile(`^((25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.){3}(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(/(3[0-2]|2[0-9]|1[0-9]|[0-9]))?$`)
+	rgxIPv6   = regexp.MustCompile(`^s*((([0-9A-Fa-f]{1,4}:){7}([0-9A-Fa-f]{1,4}|:))|(([0-9A-Fa-f]{1,4}:){6}(:[0-9A-Fa-f]{1,4}|((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3})|:))|(([0-9A-Fa-f]{1,4}:){5}(((:[0-9A-Fa-f]{1,4}){1,2})|:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3})|:))|(([0-9A-Fa-f]{1,4}:){4}(((:[0-9A-Fa-f]{1,4}){1,3})|((:[0-9A-Fa-f]{1,4})?:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(([0-9A-Fa-f]{1,4}:){3}(((:[0-9A-Fa-f]{1,4}){1,4})|((:[0-9A-Fa-f]{1,4}){0,2}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(([0-9A-Fa-f]{1,4}:){2}(((:[0-9A-Fa-f]{1,4}){1,5})|((:[0-9A-Fa-f]{1,4}){0,3}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(([0-9A-Fa-f]{1,4}:){1}(((:[0-9A-Fa-f]{1,4}){1,6})|((:[0-9A-Fa-f]{1,4}){0,4}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:))|(:(((:[0-9A-Fa-f]{1,4}){1,7})|((:[0-9A-Fa-f]{1,4}){0,5}:((25[0-5]|2[0-4]d|1dd|[1-9]?d)(.(25[0-5]|2[0-4]d|1dd|[1-9]?d)){3}))|:)))(%.+)?s*(\/([0-9]|[1-9][0-9]|1[0-1][0-9]|12[0-8]))?$`)
+	rgxDomain = regexp.MustCompile(`^(([a-zA-Z0-9А-яёЁ\*]|[a-zA-Z0-9А-яёЁ][a-zA-Z0-9А-яёЁ\-]*[a-zA-Z0-9А-яёЁ])\.)*([A-Za-z0-9А-яёЁ]|[A-Za-z0-9А-яёЁ][A-Za-z0-9А-яёЁ\-]*[A-Za-z0-9А-яёЁ])$`)
+)
+
+func parseDefaultList(input io.Reader, source Source, emit func(Entry)) error {
+	return scanLines(input, func(line string) {
+		// Извлекаем домен
+		if rgxDomain.MatchString(line) {
+			emit(Entry{Value: line})
+			return
+		}
+
+		// Извлекаем IPv4-адрес
+		if rgxIPv4.MatchString(line) {
+			emit(Entry{Value: line, IsIP: true})
+			return
+		}
+
+		// Извлекаем IPv6-адрес
+		if rgxIPv6.MatchString(line) {
+			emit(Entry{Value: line, IsIP: true})
+			return
+		}
+
+		// Если строка не была комментарием или пустой строкой, выводим предупреждение, что не удалось распарсить строку
+		// (так как такие строки отсекаются регулярками, нет смысла делать проверку перед регулярками)
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+			logWarn.Printf("Failed to parse '%s' as an IPv4, IPv6, or domain address", line)
+		}
+	})
+}
+
+func parseHostsFile(input io.Reader, source Source, emit func(Entry)) error {
+	return scanLines(input, func(line string) {
+		// Пропускаем пустые строки и комментарии
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			return
+		}
+
+		// Разбиваем строку на слова
+		words := strings.Fields(line)
+
+		// Если в строке два слова
+		if len(words) == 2 {
+			var ip = words[0]
+			var domain = words[1]
+
+			// Добавляем IP, есом он не адрес вида 127.0.0.1, 0.0.0.0, ::1 и т.п.
+			if !isLoopbackIP(ip) {
+				emit(Entry{Value: ip, IsIP: true})
+			}
+
+			// Добавялем домен, если он не localhost
+			if domain != "localhost" {
+				emit(Entry{Value: domain})
+			}
+		}
+	})
+}
+
+// Проверяем, является ли переданный IP адрес "зацикленным" (loopback)
+func isLoopbackIP(ip string) bool {
+	loopbackPatterns := []string{"127.", "0.0.0.0", "::1"}
+	for _, pattern := range loopbackPatterns {
+		if strings.HasPrefix(ip, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	box "github.com/sagernet/sing-box"
+	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing-box/option"
+	M "github.com/sagernet/sing/common/metadata"
+)
+
+// proxyDirect значение прокси, отключающее глобальный прокси для источника
+const proxyDirect = "direct"
+
+// proxyOutboundTag тег outbound'а во встроенном экземпляре sing-box
+const proxyOutboundTag = "download-proxy"
+
+// ProxyOption прокси для скачивания источников. В JSON задаётся строкой со ссылкой
+// (http://, https://, socks5://), строкой "direct" или объектом outbound'а sing-box
+type ProxyOption struct {
+	URL      string           // Ссылка на прокси или "direct"
+	Outbound *option.Outbound // Описание outbound'а sing-box
+	key      string           // Исходный JSON outbound'а (для переиспользования запущенного sing-box)
+}
+
+// UnmarshalJSON разбирает ProxyOption из строки или объекта
+func (p *ProxyOption) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &p.URL)
+	}
+
+	var outbound option.Outbound
+	if err := outbound.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("invalid sing-box outbound: %v", err)
+	}
+	p.Outbound = &outbound
+	p.key = string(data)
+	return nil
+}
+
+// String возвращает описание прокси для логов (без учётных данных)
+func (p *ProxyOption) String() string {
+	if p.Outbound != nil {
+		return "sing-box " + p.Outbound.Type + " outbound"
+	}
+	if u, err := url.Parse(p.URL); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return p.URL
+}
+
+// parseProxyOption разбирает значение флага --proxy: ссылку, "direct", JSON outbound'а sing-box
+// или путь к JSON-файлу с outbound'ом. Пустая строка означает, что прокси не задан
+func parseProxyOption(value string) (*ProxyOption, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return nil, nil
+	case value == proxyDirect || strings.Contains(value, "://"):
+		return &ProxyOption{URL: value}, nil
+	}
+
+	// Если это не JSON, то это путь к файлу с outbound'ом
+	data := []byte(value)
+	if !strings.HasPrefix(value, "{") {
+		var err error
+		data, err = os.ReadFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read proxy outbound file: %v", err)
+		}
+	}
+
+	var proxy ProxyOption
+	if err := proxy.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return &proxy, nil
+}
+
+// proxyPool запускает встроенные экземпляры sing-box для outbound'ов и переиспользует их между источниками
+type proxyPool struct {
+	mu    sync.Mutex
+	boxes map[string]*box.Box
+}
+
+func newProxyPool() *proxyPool {
+	return &proxyPool{boxes: map[string]*box.Box{}}
+}
+
+// configure направляет соединения transport через прокси proxy (nil - прокси из переменных окружения)
+func (p *proxyPool) configure(transport *http.Transport, proxy *ProxyOption) error {
+	switch {
+	case proxy == nil:
+		return nil
+
+	case proxy.Outbound != nil:
+		outbound, err := p.outbound(proxy)
+		if err != nil {
+			return err
+		}
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return outbound.DialContext(ctx, network, M.ParseSocksaddr(address))
+		}
+		return nil
+
+	case proxy.URL == proxyDirect:
+		transport.Proxy = nil
+		return nil
+	}
+
+	// Логин и пароль в ссылке на прокси могут браться из переменных окружения
+	rawURL, err := expandSecrets(proxy.URL)
+	if err != nil {
+		return fmt.Errorf("proxy: %v", err)
+	}
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	case "socks5h":
+		// net/http всегда передаёт имя хоста SOCKS5-прокси, поэтому socks5h эквивалентен socks5
+		proxyURL.Scheme = "socks5"
+	default:
+		return fmt.Errorf("unsupported proxy scheme '%s', expected http, https, socks5 or a sing-box outbound", proxyURL.Scheme)
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return nil
+}
+
+// outbound возвращает outbound запущенного экземпляра sing-box, при необходимости запуская его
+func (p *proxyPool) outbound(proxy *ProxyOption) (adapter.Outbound, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	instance, ok := p.boxes[proxy.key]
+	if !ok {
+		outboundOptions := *proxy.Outbound
+		outboundOptions.Tag = proxyOutboundTag
+
+		var err error
+		instance, err = box.New(box.Options{
+			Context: context.Background(),
+			Options: option.Options{
+				Log:       &option.LogOptions{Disabled: true},
+				Outbounds: []option.Outbound{outboundOptions},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot create sing-box outbound: %v", err)
+		}
+		if err := instance.Start(); err != nil {
+			instance.Close()
+			return nil, fmt.Errorf("cannot start sing-box outbound: %v", err)
+		}
+		logInfo.Printf("started %s for downloading", proxy)
+		p.boxes[proxy.key] = instance
+	}
+
+	outbound, ok := instance.Router().Outbound(proxyOutboundTag)
+	if !ok {
+		return nil, fmt.Errorf("sing-box outbound '%s' not found", proxyOutboundTag)
+	}
+	return outbound, nil
+}
+
+// Close останавливает все запущенные экземпляры sing-box
+func (p *proxyPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, instance := range p.boxes {
+		if err := instance.Close(); err != nil {
+			logWarn.Printf("cannot stop sing-box outbound: %v", err)
+		}
+		delete(p.boxes, key)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"path/filepath"
@@ -49,6 +50,69 @@ func processFiles(folderPath string) ([]FileData, error) {
 	return fileDataArray, nil
 }
 
+// groupFileData объединяет файлы с одинаковыми параметрами (include/exclude, ip/domain, lst/rgx, категория),
+// например, одноимённые файлы из разных поддиректорий. Файлы include объединяются без учёта lst/rgx,
+// чтобы на каждую категорию был один include файл и один итоговый список. Порядок групп - порядок первого файла группы
+func groupFileData(files []FileData) []FileData {
+	var grouped []FileData
+	index := map[string]int{}
+
+	for _, fileData := range files {
+		// Регулярные выражения используются только для исключений, поэтому include .rgx идёт в одну группу с .lst
+		isRegexp := fileData.IsRegexp && !fileData.IsInclude
+		key := fmt.Sprintf("%t-%t-%t-%s", fileData.IsInclude, fileData.IsIP, isRegexp, fileData.Category)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(grouped)
+			grouped = append(grouped, fileData)
+			continue
+		}
+		logInfo.Printf("file '%s' is merged with '%s'", fileData.Path, grouped[i].Path)
+		grouped[i] = mergeFileData(grouped[i], fileData)
+	}
+
+	return grouped
+}
+
+// mergeFileData объединяет содержимое двух файлов с одинаковыми параметрами, убирая дубликаты.
+// Группа считается файлом .rgx, только если все её файлы .rgx
+func mergeFileData(a, b FileData) FileData {
+	merged := a
+	merged.Path = a.Path + ", " + b.Path
+	merged.IsRegexp = a.IsRegexp && b.IsRegexp
+	merged.Content = uniqueSlice(append(append([]string{}, a.Content...), b.Content...))
+
+	// Регулярные выражения сравниваем по их исходной строке
+	seenRegex := map[string]bool{}
+	merged.Regex = nil
+	for _, rx := range append(append([]*regexp.Regexp{}, a.Regex...), b.Regex...) {
+		if !seenRegex[rx.String()] {
+			seenRegex[rx.String()] = true
+			merged.Regex = append(merged.Regex, rx)
+		}
+	}
+
+	seenIP := map[string]bool{}
+	merged.IpAddresses = nil
+	for _, ip := range append(append([]net.IP{}, a.IpAddresses...), b.IpAddresses...) {
+		if !seenIP[ip.String()] {
+			seenIP[ip.String()] = true
+			merged.IpAddresses = append(merged.IpAddresses, ip)
+		}
+	}
+
+	seenNetwork := map[string]bool{}
+	merged.IpNetworks = nil
+	for _, network := range append(append([]net.IPNet{}, a.IpNetworks...), b.IpNetworks...) {
+		if !seenNetwork[network.String()] {
+			seenNetwork[network.String()] = true
+			merged.IpNetworks = append(merged.IpNetworks, network)
+		}
+	}
+
+	return merged
+}
+
 // getFilesInFolder возвращает список .lst и .rgx файлов в заданной папке
 func getFilesInFolder(folderPath string) ([]string, error) {
 	var files []string
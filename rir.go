@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"go4.org/netipx"
+)
+
+// parseRirDelegated разбирает статистику распределения адресов RIR (delegated-*-latest и delegated-*-extended-latest
+// от RIPE NCC, ARIN, APNIC, LACNIC, AFRINIC). Строка имеет вид registry|cc|type|start|value|date|status[|opaque-id]:
+// для ipv4 value - количество адресов (оно не обязано быть степенью двойки, поэтому диапазон разбивается на сети),
+// для ipv6 - длина префикса. Страны выбираются полями countries и categories, как у CountryIPs
+func parseRirDelegated(input io.Reader, source Source, emit func(Entry)) error {
+	selection, err := newCountrySelection(source)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	err = scanLines(input, func(line string) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return
+		}
+
+		fields := strings.Split(line, "|")
+		// Строка версии (2|ripencc|serial|records|startdate|enddate|UTCoffset) начинается с номера формата
+		if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			return
+		}
+		// Сводные строки (registry|*|ipv4|*|count|summary) не описывают блоки адресов
+		if len(fields) >= 6 && fields[5] == "summary" {
+			return
+		}
+		if len(fields) < 7 {
+			invalid++
+			return
+		}
+		// Свободные и зарезервированные блоки (в extended-файлах) никакой стране не принадлежат
+		status := strings.ToLower(fields[6])
+		if status != "allocated" && status != "assigned" {
+			return
+		}
+
+		code, kind, start, value := fields[1], fields[2], fields[3], fields[4]
+		switch kind {
+		case "ipv4":
+			first, err := netip.ParseAddr(start)
+			count, errCount := strconv.ParseUint(value, 10, 32)
+			if err != nil || errCount != nil || !first.Is4() || count == 0 {
+				invalid++
+				return
+			}
+			last := addToIPv4(first, count-1)
+			ipRange := netipx.IPRangeFrom(first, last)
+			if !last.IsValid() || !ipRange.IsValid() {
+				invalid++
+				return
+			}
+			for _, prefix := range ipRange.Prefixes() {
+				selection.emit(code, prefix, emit)
+			}
+		case "ipv6":
+			bits, errBits := strconv.Atoi(value)
+			prefix, err := netip.ParsePrefix(start + "/" + value)
+			if err != nil || errBits != nil || !prefix.Addr().Is6() || bits < 0 {
+				invalid++
+				return
+			}
+			selection.emit(code, prefix.Masked(), emit)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if invalid > 0 {
+		logWarn.Printf("source '%s': skipped %d invalid records", source.URL, invalid)
+	}
+	// Каждый RIR публикует только свои страны, поэтому отсутствие страны в файле - не ошибка
+	if missing := selection.missing(); len(missing) > 0 {
+		logInfo.Printf("source '%s': no records for countries %s in '%s'", source.URL, strings.Join(missing, ", "), source.document)
+	}
+	return nil
+}
+
+// addToIPv4 прибавляет n к IPv4-адресу. Возвращает пустой адрес при переполнении
+func addToIPv4(addr netip.Addr, n uint64) netip.Addr {
+	bytes := addr.As4()
+	value := uint64(bytes[0])<<24 | uint64(bytes[1])<<16 | uint64(bytes[2])<<8 | uint64(bytes[3])
+	value += n
+	if value > 1<<32-1 {
+		return netip.Addr{}
+	}
+	return netip.AddrFrom4([4]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testRirDelegated = `2|ripencc|1701385199|4|19830705|20231130|+0100
+# comment
+ripencc|*|ipv4|*|3|summary
+ripencc|*|ipv6|*|1|summary
+ripencc|RU|ipv4|10.0.0.0|768|20100101|allocated
+ripencc|RU|ipv4|10.1.0.0|256|20100101|assigned|opaque
+ripencc|RU|ipv4|10.2.0.1|3|20100101|allocated
+ripencc|RU|ipv6|2001:db8::|32|20100101|allocated
+ripencc|DE|ipv4|10.3.0.0|1024|20100101|allocated
+ripencc||ipv4|10.4.0.0|256||available
+ripencc|RU|ipv4|10.5.0.0|0|20100101|allocated
+ripencc|RU|ipv4|10.6.0.0
+`
+
+func TestParseRirDelegated(t *testing.T) {
+	tests := []struct {
+		name       string
+		countries  []string
+		categories map[string]string
+		expected   []string
+	}{
+		{
+			name:      "ru",
+			countries: []string{"ru"},
+			// 768 адресов - это /23 и /24, 3 адреса с 10.2.0.1 - /32 и /31
+			expected: []string{
+				"ru:10.0.0.0/23", "ru:10.0.2.0/24", "ru:10.1.0.0/24", "ru:10.2.0.1/32", "ru:10.2.0.2/31", "ru:2001:db8::/32",
+			},
+		},
+		{
+			name:       "categories",
+			categories: map[string]string{"DE": "de"},
+			expected:   []string{"de:10.3.0.0/22"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []string
+			source := Source{URL: "test", Category: "ru", Countries: test.countries, Categories: test.categories}
+			err := parseRirDelegated(strings.NewReader(testRirDelegated), source, func(entry Entry) {
+				entries = append(entries, entry.Category+":"+entry.Value)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, entries)
+			}
+		})
+	}
+}
+
+func TestAddToIPv4(t *testing.T) {
+	tests := []struct {
+		addr     string
+		n        uint64
+		expected string
+	}{
+		{addr: "10.0.0.0", n: 767, expected: "10.0.2.255"},
+		{addr: "10.0.0.255", n: 1, expected: "10.0.1.0"},
+		{addr: "255.255.255.255", n: 0, expected: "255.255.255.255"},
+		{addr: "255.255.255.255", n: 1, expected: "invalid IP"},
+	}
+	for _, test := range tests {
+		addr := addToIPv4(netip.MustParseAddr(test.addr), test.n)
+		if addr.String() != test.expected {
+			t.Errorf("%s + %d: expected %s, got %s", test.addr, test.n, test.expected, addr)
+		}
+	}
+}
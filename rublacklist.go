@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Префиксы ключей categories для JsonRublacklistRegistry
+const (
+	rublacklistNamePrefix = "name:" // Название ресурса (name)
+	rublacklistCodePrefix = "code:" // Код ограничения (restriction.code)
+	rublacklistTypePrefix = "type:" // Тип блокировки (blockType)
+)
+
+// Названия полей записей реестра (без учёта регистра, "_" и "-"). API разных версий называет поля по-разному
+var (
+	rublacklistDomainFields     = []string{"domains", "domain"}
+	rublacklistDomainMaskFields = []string{"domainmasks", "domainmask"}
+	rublacklistIPFields         = []string{"ips", "ip", "ipsubnets", "ipsubnet", "subnets", "subnet"}
+	rublacklistTypeFields       = []string{"blocktype", "blockingtype", "type"}
+	rublacklistCodeFields       = []string{"restrictioncode", "code"}
+	rublacklistContainerFields  = []string{"results", "records", "items", "data"}
+)
+
+// rublacklistRouter выбирает категорию записи реестра по названию ресурса, коду ограничения или типу блокировки
+type rublacklistRouter struct {
+	names  map[string]string // Название ресурса -> категория
+	codes  map[string]string // Код ограничения -> категория
+	types  map[string]string // Тип блокировки -> категория
+	counts map[string]int    // Количество записей по коду и типу (для лога)
+}
+
+// newRublacklistRouter разбирает categories источника: ключи "name:<название>", "code:<код>" и "type:<тип>"
+func newRublacklistRouter(categories map[string]string) (*rublacklistRouter, error) {
+	router := &rublacklistRouter{names: map[string]string{}, codes: map[string]string{}, types: map[string]string{}, counts: map[string]int{}}
+	for key, category := range categories {
+		lower := strings.ToLower(strings.TrimSpace(key))
+		switch {
+		case strings.HasPrefix(lower, rublacklistNamePrefix):
+			router.names[strings.TrimSpace(lower[len(rublacklistNamePrefix):])] = category
+		case strings.HasPrefix(lower, rublacklistCodePrefix):
+			router.codes[strings.TrimSpace(lower[len(rublacklistCodePrefix):])] = category
+		case strings.HasPrefix(lower, rublacklistTypePrefix):
+			router.types[strings.TrimSpace(lower[len(rublacklistTypePrefix):])] = category
+		default:
+			return nil, fmt.Errorf("invalid key '%s' in 'categories': expected 'name:<resource name>', 'code:<restriction code>' or 'type:<blocking type>'", key)
+		}
+	}
+	return router, nil
+}
+
+// category возвращает категорию записи: по названию ресурса, затем по коду ограничения, затем по типу блокировки.
+// Пустая строка - категория источника
+func (r *rublacklistRouter) category(name, code, blockType string) string {
+	name, code, blockType = strings.ToLower(name), strings.ToLower(code), strings.ToLower(blockType)
+	r.counts[fmt.Sprintf("code '%s', type '%s'", code, blockType)]++
+	if category, ok := r.names[name]; ok && name != "" {
+		return category
+	}
+	if category, ok := r.codes[code]; ok && code != "" {
+		return category
+	}
+	if category, ok := r.types[blockType]; ok && blockType != "" {
+		return category
+	}
+	return ""
+}
+
+// parseJsonRublacklistRegistry разбирает ответы API реестра Роскомсвободы (Rublacklist): массив записей
+// или объект с массивом записей в results/records/items/data. Из записей берутся домены, маски доменов и IP-адреса,
+// а категория записи выбирается полем categories по названию ресурса (name), коду ограничения (restriction.code)
+// или типу блокировки (blockType).
+// Записи без подходящей категории попадают в категорию источника, а если она не задана - пропускаются
+func parseJsonRublacklistRegistry(input io.Reader, source Source, emit func(Entry)) error {
+	if source.Category == "" && len(source.Categories) == 0 {
+		return fmt.Errorf("'category' or 'categories' is required for the %s content type", source.ContentType)
+	}
+	router, err := newRublacklistRouter(source.Categories)
+	if err != nil {
+		return err
+	}
+
+	invalid, unmatched := 0, 0
+	handle := func(record map[string]interface{}) {
+		fields := map[string]interface{}{}
+		for key, value := range record {
+			fields[normalizeRublacklistField(key)] = value
+		}
+		// Код ограничения может быть вложенным объектом: "restriction": {"code": "..."}
+		code := firstRublacklistString(fields, rublacklistCodeFields)
+		if restriction, ok := fields["restriction"].(map[string]interface{}); ok && code == "" {
+			code = jsonString(restriction["code"])
+		}
+		category := router.category(jsonString(fields["name"]), code, firstRublacklistString(fields, rublacklistTypeFields))
+		// Без category записи без подходящей категории некуда записать
+		if category == "" && source.Category == "" {
+			unmatched++
+			return
+		}
+
+		for _, domain := range rublacklistStrings(fields, rublacklistDomainFields) {
+			domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+			if !rgxDomain.MatchString(domain) || strings.Contains(domain, "*") {
+				invalid++
+				continue
+			}
+			emit(Entry{Value: domain, Category: category})
+		}
+		// Маска "*.example.com" блокирует все поддомены
+		for _, mask := range rublacklistStrings(fields, rublacklistDomainMaskFields) {
+			domain := strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(mask), "."), "*.")
+			if !rgxDomain.MatchString(domain) || strings.Contains(domain, "*") {
+				invalid++
+				continue
+			}
+			emit(Entry{Value: "*." + domain, Category: category})
+		}
+		for _, ip := range rublacklistStrings(fields, rublacklistIPFields) {
+			if !isIPOrNetwork(ip) {
+				invalid++
+				continue
+			}
+			emit(Entry{Value: ip, IsIP: true, Category: category})
+		}
+	}
+
+	if err := readRublacklistRecords(input, handle); err != nil {
+		return err
+	}
+
+	// Выводим распределение записей, чтобы было видно, какие коды и типы есть в реестре
+	if len(router.counts) > 0 {
+		var counts []string
+		for key, count := range router.counts {
+			counts = append(counts, fmt.Sprintf("%s: %d", key, count))
+		}
+		sort.Strings(counts)
+		logInfo.Printf("source '%s': records by restriction code and blocking type: %s", source.URL, strings.Join(counts, "; "))
+	}
+	if unmatched > 0 {
+		logInfo.Printf("source '%s': skipped %d records without a matching category ('category' is not set)", source.URL, unmatched)
+	}
+	if invalid > 0 {
+		logWarn.Printf("source '%s': skipped %d invalid domains and IP addresses", source.URL, invalid)
+	}
+	return nil
+}
+
+// readRublacklistRecords читает записи реестра: массив разбирается потоково, из объекта берётся массив
+// в одном из полей-контейнеров
+func readRublacklistRecords(input io.Reader, handle func(record map[string]interface{})) error {
+	buffered := bufio.NewReader(input)
+	if startsWithJSONArray(buffered) {
+		return decodeJSONArray(buffered, func(decoder *json.Decoder) error {
+			var record map[string]interface{}
+			if err := decoder.Decode(&record); err != nil {
+				return err
+			}
+			handle(record)
+			return nil
+		})
+	}
+
+	var document map[string]interface{}
+	if err := json.NewDecoder(buffered).Decode(&document); err != nil {
+		return fmt.Errorf("expected a JSON array or object with records: %v", err)
+	}
+	fields := map[string]interface{}{}
+	for key, value := range document {
+		fields[normalizeRublacklistField(key)] = value
+	}
+	for _, name := range rublacklistContainerFields {
+		if records, ok := fields[name].([]interface{}); ok {
+			for _, item := range records {
+				if record, ok := item.(map[string]interface{}); ok {
+					handle(record)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no array of records found in the JSON object (expected one of: %s)", strings.Join(rublacklistContainerFields, ", "))
+}
+
+// normalizeRublacklistField приводит название поля к виду без регистра, "_" и "-" (block_type, blockType -> blocktype)
+func normalizeRublacklistField(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
+
+// jsonString возвращает строку или число из значения JSON
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// firstRublacklistString возвращает значение первого найденного поля
+func firstRublacklistString(fields map[string]interface{}, names []string) string {
+	for _, name := range names {
+		if value := jsonString(fields[name]); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// rublacklistStrings собирает строки из полей: значение может быть строкой или массивом строк
+func rublacklistStrings(fields map[string]interface{}, names []string) []string {
+	var result []string
+	for _, name := range names {
+		switch v := fields[name].(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				result = append(result, v)
+			}
+		case []interface{}:
+			for _, item := range v {
+				if value := jsonString(item); value != "" {
+					result = append(result, value)
+				}
+			}
+		}
+	}
+	return result
+}
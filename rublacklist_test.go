@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testRublacklistRecords записи реестра с разными названиями полей
+const testRublacklistRecords = `[
+	{"name": "Telegram", "domains": ["telegram.org"], "ips": ["149.154.160.0/20"], "restriction": {"code": "ya"}, "blockType": "ip"},
+	{"name": "Other", "domain": "a.com", "restriction_code": "YA", "block_type": "domain"},
+	{"domain_mask": "*.b.com", "blockType": "domain-mask", "ip": "not-an-ip"},
+	{"domains": ["c.com.", "bad_domain!"], "blockType": "domain", "code": "other"},
+	{"domains": ["d.com"]}
+]`
+
+func TestParseJsonRublacklistRegistry(t *testing.T) {
+	tests := []struct {
+		name       string
+		category   string
+		categories map[string]string
+		input      string
+		expected   []string
+	}{
+		{
+			// Название ресурса важнее кода, код важнее типа
+			name:       "routing",
+			category:   "blocked",
+			categories: map[string]string{"name:telegram": "telegram", "code:ya": "ya", "Type:Domain": "domains"},
+			input:      testRublacklistRecords,
+			expected: []string{
+				"telegram:telegram.org", "telegram:149.154.160.0/20", "ya:a.com", "blocked:*.b.com", "domains:c.com", "blocked:d.com",
+			},
+		},
+		{
+			// Без category записи без подходящей категории пропускаются
+			name:       "without category",
+			categories: map[string]string{"type:domain-mask": "masks"},
+			input:      testRublacklistRecords,
+			expected:   []string{"masks:*.b.com"},
+		},
+		{
+			name:     "object with records",
+			category: "blocked",
+			input:    `{"total": 1, "Results": [{"domains": ["e.com"]}]}`,
+			expected: []string{"blocked:e.com"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var entries []string
+			source := Source{URL: "test", ContentType: JsonRublacklistRegistry, Category: test.category, Categories: test.categories}
+			err := parseJsonRublacklistRegistry(strings.NewReader(test.input), source, func(entry Entry) {
+				category := entry.Category
+				if category == "" {
+					category = source.Category
+				}
+				entries = append(entries, category+":"+entry.Value)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, entries)
+			}
+		})
+	}
+}
+
+func TestParseJsonRublacklistRegistryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source Source
+		input  string
+	}{
+		{name: "no category", source: Source{}, input: "[]"},
+		{name: "invalid key", source: Source{Categories: map[string]string{"telegram": "telegram"}}, input: "[]"},
+		{name: "no records", source: Source{Category: "blocked"}, input: `{"total": 0}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := parseJsonRublacklistRegistry(strings.NewReader(test.input), test.source, func(Entry) {}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
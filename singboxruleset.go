@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sagernet/sing-box/common/srs"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/domain"
+)
+
+// ruleSetRule правило rule-set'а sing-box, приведённое к общему виду для JSON и .srs
+type ruleSetRule struct {
+	Logical       bool          // true, если правило логическое (объединяет вложенные правила)
+	Mode          string        // Режим логического правила: "and" или "or"
+	Invert        bool          // Правило инвертировано
+	Domain        []string      // Домены
+	DomainSuffix  []string      // Суффиксы доменов
+	DomainKeyword []string      // Ключевые слова
+	DomainRegex   []string      // Регулярные выражения
+	IPCIDR        []string      // IP-адреса и сети
+	Rules         []ruleSetRule // Вложенные правила логического правила
+	Unsupported   []string      // Поля правила, которые нельзя перенести в списки (порты, процессы и т.п.)
+}
+
+// parseSingBoxRuleSet разбирает rule-set sing-box: исходный JSON (любой версии) или скомпилированный .srs.
+// Формат определяется по сигнатуре файла. Поля domain, domain_suffix, domain_keyword, domain_regex и ip_cidr
+// переводятся в записи списков. Правила с другими полями, инвертированные правила и логические правила "and"
+// сужают совпадение, поэтому пропускаются, их количество выводится в лог
+func parseSingBoxRuleSet(input io.Reader, source Source, emit func(Entry)) error {
+	buffered := bufio.NewReader(input)
+	magic, _ := buffered.Peek(len(srs.MagicBytes))
+
+	var rules []ruleSetRule
+	var err error
+	if bytes.Equal(magic, srs.MagicBytes[:]) {
+		rules, err = readBinaryRuleSet(buffered)
+	} else {
+		rules, err = readJSONRuleSet(buffered)
+	}
+	if err != nil {
+		return err
+	}
+
+	skipped := map[string]int{}
+	for _, rule := range rules {
+		emitRuleSetRule(rule, emit, skipped)
+	}
+
+	// Сообщаем, какие правила не удалось перенести
+	if len(skipped) > 0 {
+		var reasons []string
+		total := 0
+		for reason, count := range skipped {
+			reasons = append(reasons, fmt.Sprintf("%s (%d)", reason, count))
+			total += count
+		}
+		sort.Strings(reasons)
+		logWarn.Printf("source '%s': skipped %d rules that cannot be represented: %s", source.URL, total, strings.Join(reasons, ", "))
+	}
+	return nil
+}
+
+// emitRuleSetRule передаёт записи правила в emit. Логическое правило "or" - объединение вложенных правил,
+// поэтому оно раскрывается. Причины пропуска правил считаются в skipped
+func emitRuleSetRule(rule ruleSetRule, emit func(Entry), skipped map[string]int) {
+	switch {
+	case rule.Invert:
+		skipped["invert"]++
+		return
+	case rule.Logical && rule.Mode != "or":
+		skipped["logical "+rule.Mode]++
+		return
+	case rule.Logical:
+		for _, subRule := range rule.Rules {
+			emitRuleSetRule(subRule, emit, skipped)
+		}
+		return
+	case len(rule.Unsupported) > 0:
+		sort.Strings(rule.Unsupported)
+		skipped[strings.Join(rule.Unsupported, "+")]++
+		return
+	}
+
+	// Суффикс ".example.com" - только поддомены, "example.com" - домен и поддомены.
+	// Пара из домена "example.com" и суффикса ".example.com" (так пишет этот генератор) - тоже домен и поддомены
+	domains := map[string]bool{}
+	for _, value := range rule.Domain {
+		domains[strings.ToLower(value)] = true
+	}
+	for _, suffix := range rule.DomainSuffix {
+		suffix = strings.ToLower(suffix)
+		if !strings.HasPrefix(suffix, ".") {
+			emit(Entry{Value: "*." + suffix})
+			continue
+		}
+		if domains[suffix[1:]] {
+			emit(Entry{Value: "*" + suffix})
+			delete(domains, suffix[1:])
+			continue
+		}
+		emit(Entry{Value: "regexp:^.+" + regexp.QuoteMeta(suffix) + "$"})
+	}
+	for _, value := range rule.Domain {
+		if value = strings.ToLower(value); domains[value] {
+			emit(Entry{Value: value})
+		}
+	}
+	for _, keyword := range rule.DomainKeyword {
+		emit(Entry{Value: "keyword:" + strings.ToLower(keyword)})
+	}
+	for _, regex := range rule.DomainRegex {
+		emit(Entry{Value: "regexp:" + regex})
+	}
+	for _, cidr := range rule.IPCIDR {
+		emit(Entry{Value: cidr, IsIP: true})
+	}
+}
+
+// readJSONRuleSet читает исходный rule-set sing-box в формате JSON. Правила разбираются вручную,
+// чтобы поддержать любые версии формата и сообщить о полях, которые нельзя перенести в списки
+func readJSONRuleSet(input io.Reader) ([]ruleSetRule, error) {
+	var ruleSet struct {
+		Version int               `json:"version"`
+		Rules   []json.RawMessage `json:"rules"`
+	}
+	if err := json.NewDecoder(input).Decode(&ruleSet); err != nil {
+		return nil, fmt.Errorf("invalid rule-set JSON: %v", err)
+	}
+	logInfo.Printf("rule-set version %d with %d rules", ruleSet.Version, len(ruleSet.Rules))
+	return decodeJSONRuleSetRules(ruleSet.Rules)
+}
+
+// decodeJSONRuleSetRules разбирает список правил rule-set'а
+func decodeJSONRuleSetRules(rawRules []json.RawMessage) ([]ruleSetRule, error) {
+	rules := make([]ruleSetRule, 0, len(rawRules))
+	for i, rawRule := range rawRules {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(rawRule, &fields); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+
+		var rule ruleSetRule
+		var err error
+		for name, value := range fields {
+			switch name {
+			case "type":
+				var ruleType string
+				err = json.Unmarshal(value, &ruleType)
+				rule.Logical = ruleType == "logical"
+			case "mode":
+				err = json.Unmarshal(value, &rule.Mode)
+			case "invert":
+				err = json.Unmarshal(value, &rule.Invert)
+			case "rules":
+				var subRules []json.RawMessage
+				if err = json.Unmarshal(value, &subRules); err == nil {
+					rule.Rules, err = decodeJSONRuleSetRules(subRules)
+				}
+			case "domain":
+				rule.Domain, err = decodeListable(value)
+			case "domain_suffix":
+				rule.DomainSuffix, err = decodeListable(value)
+			case "domain_keyword":
+				rule.DomainKeyword, err = decodeListable(value)
+			case "domain_regex":
+				rule.DomainRegex, err = decodeListable(value)
+			case "ip_cidr":
+				rule.IPCIDR, err = decodeListable(value)
+			default:
+				rule.Unsupported = append(rule.Unsupported, name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid '%s': %v", i, name, err)
+			}
+		}
+		rule.Mode = strings.ToLower(rule.Mode)
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// decodeListable разбирает поле, которое может быть строкой или массивом строк
+func decodeListable(value json.RawMessage) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(value, &list); err == nil {
+		return list, nil
+	}
+	var single string
+	if err := json.Unmarshal(value, &single); err != nil {
+		return nil, err
+	}
+	return []string{single}, nil
+}
+
+// readBinaryRuleSet читает скомпилированный rule-set sing-box (.srs)
+func readBinaryRuleSet(input io.Reader) ([]ruleSetRule, error) {
+	ruleSet, err := srs.Read(input, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid .srs rule-set: %v", err)
+	}
+	logInfo.Printf("compiled rule-set with %d rules", len(ruleSet.Rules))
+
+	rules := make([]ruleSetRule, 0, len(ruleSet.Rules))
+	for i, headlessRule := range ruleSet.Rules {
+		rule, err := convertHeadlessRule(headlessRule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// convertHeadlessRule приводит правило из .srs к общему виду
+func convertHeadlessRule(headlessRule option.HeadlessRule) (ruleSetRule, error) {
+	// srs.Read не заполняет тип правила, логическое правило узнаём по вложенным правилам
+	if headlessRule.Type == "logical" || len(headlessRule.LogicalOptions.Rules) > 0 {
+		logical := headlessRule.LogicalOptions
+		rule := ruleSetRule{Logical: true, Mode: strings.ToLower(logical.Mode), Invert: logical.Invert}
+		for _, subRule := range logical.Rules {
+			converted, err := convertHeadlessRule(subRule)
+			if err != nil {
+				return ruleSetRule{}, err
+			}
+			rule.Rules = append(rule.Rules, converted)
+		}
+		return rule, nil
+	}
+
+	options := headlessRule.DefaultOptions
+	rule := ruleSetRule{
+		Invert:        options.Invert,
+		DomainKeyword: options.DomainKeyword,
+		DomainRegex:   options.DomainRegex,
+		IPCIDR:        options.IPCIDR,
+	}
+	// Домены и суффиксы в .srs хранятся вместе в сжатом дереве, извлекаем их из него
+	if options.DomainMatcher != nil {
+		var err error
+		rule.Domain, rule.DomainSuffix, err = dumpDomainMatcher(options.DomainMatcher)
+		if err != nil {
+			return ruleSetRule{}, fmt.Errorf("cannot read domains: %v", err)
+		}
+	}
+
+	// Остальные поля сужают совпадение правила
+	unsupported := map[string]bool{
+		"query_type":        len(options.QueryType) > 0,
+		"network":           len(options.Network) > 0,
+		"source_ip_cidr":    len(options.SourceIPCIDR) > 0 || options.SourceIPSet != nil,
+		"source_port":       len(options.SourcePort) > 0,
+		"source_port_range": len(options.SourcePortRange) > 0,
+		"port":              len(options.Port) > 0,
+		"port_range":        len(options.PortRange) > 0,
+		"process_name":      len(options.ProcessName) > 0,
+		"process_path":      len(options.ProcessPath) > 0,
+		"package_name":      len(options.PackageName) > 0,
+		"wifi_ssid":         len(options.WIFISSID) > 0,
+		"wifi_bssid":        len(options.WIFIBSSID) > 0,
+	}
+	for name, present := range unsupported {
+		if present {
+			rule.Unsupported = append(rule.Unsupported, name)
+		}
+	}
+	return rule, nil
+}
+
+const (
+	// srsDomainTreeVersion версия формата дерева доменов, которую пишет domain.Matcher.Write
+	srsDomainTreeVersion = 1
+	// srsSuffixLabel метка, которой в дереве доменов sing-box отмечен суффикс (prefixLabel в sing)
+	srsSuffixLabel = '\r'
+)
+
+// dumpDomainMatcher извлекает домены и суффиксы из сжатого дерева доменов sing-box. Публичного способа
+// перечислить ключи в sing нет (domain.Matcher умеет только NewMatcher, ReadMatcher, Match и Write),
+// поэтому дерево сериализуется и разбирается заново. Разбор опирается на внутренний формат succinctSet
+// из common/domain/set.go и matcher.go в github.com/sagernet/sing v0.2.18-0.20231201060417-575186ed63c2:
+// байт версии 1, leaves и labelBitmap (длина uvarint и uint64 big-endian), labels (длина uvarint и байты).
+// Это LOUDS-дерево, в котором узлы пронумерованы в порядке обхода в ширину, а ключи - домены, записанные
+// задом наперёд, у суффиксов с меткой '\r' в конце. При обновлении sing формат нужно сверить заново:
+// результат разбора проверяется повторной сборкой дерева, и при расхождении возвращается ошибка
+func dumpDomainMatcher(matcher *domain.Matcher) ([]string, []string, error) {
+	var buffer bytes.Buffer
+	if err := matcher.Write(&buffer); err != nil {
+		return nil, nil, err
+	}
+	serialized := bytes.Clone(buffer.Bytes())
+
+	// Формат: версия, листья (битовая карта узлов-ключей), битовая карта меток, метки
+	reader := bufio.NewReader(&buffer)
+	version, err := reader.ReadByte()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != srsDomainTreeVersion {
+		return nil, nil, fmt.Errorf("unsupported domain tree version %d", version)
+	}
+	leaves, err := readUint64Slice(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	labelBitmap, err := readUint64Slice(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	labelsLength, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	labels := make([]byte, labelsLength)
+	if _, err := io.ReadFull(reader, labels); err != nil {
+		return nil, nil, err
+	}
+
+	// В битовой карте меток у каждого узла идут нули (по одному на дочерний узел) и завершающая единица.
+	// Дочерние узлы нумеруются по порядку нулей, начиная с 1 (0 - корень)
+	parents := []int{-1}
+	nodeLabels := []byte{0}
+	node, zeros := 0, 0
+	for i := 0; i < len(labelBitmap)*64 && zeros <= len(labels) && node < len(parents); i++ {
+		if labelBitmap[i>>6]&(1<<uint(i&63)) != 0 {
+			node++
+			continue
+		}
+		if zeros >= len(labels) {
+			return nil, nil, fmt.Errorf("corrupted domain tree")
+		}
+		parents = append(parents, node)
+		nodeLabels = append(nodeLabels, labels[zeros])
+		zeros++
+	}
+
+	// Восстанавливаем ключи узлов-листьев по цепочке родителей
+	var domains, suffixes []string
+	for id := 1; id < len(parents); id++ {
+		if id>>6 >= len(leaves) || leaves[id>>6]&(1<<uint(id&63)) == 0 {
+			continue
+		}
+		var key []byte
+		for current := id; current > 0; current = parents[current] {
+			key = append(key, nodeLabels[current])
+		}
+		// Ключ собран от листа к корню, то есть это домен в прямом порядке байт, но с перевёрнутыми
+		// многобайтовыми символами - переворачиваем обратно каждый символ
+		if key[0] == srsSuffixLabel {
+			suffixes = append(suffixes, fixReversedRunes(key[1:]))
+		} else {
+			domains = append(domains, fixReversedRunes(key))
+		}
+	}
+
+	// Дерево из восстановленных ключей должно совпасть с исходным байт в байт, иначе формат изменился
+	var rebuilt bytes.Buffer
+	if err := domain.NewMatcher(domains, suffixes).Write(&rebuilt); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(rebuilt.Bytes(), serialized) {
+		return nil, nil, fmt.Errorf("unsupported domain tree layout")
+	}
+	return domains, suffixes, nil
+}
+
+// readUint64Slice читает массив uint64 с длиной в формате uvarint
+func readUint64Slice(reader *bufio.Reader) ([]uint64, error) {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint64, length)
+	return values, binary.Read(reader, binary.BigEndian, values)
+}
+
+// fixReversedRunes восстанавливает домен, у которого байты каждого многобайтового символа идут в обратном порядке
+func fixReversedRunes(data []byte) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	var result []byte
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			result = append(result, data[i])
+			i++
+			continue
+		}
+		// Продолжающие байты (10xxxxxx) идут перед начальным байтом символа
+		j := i
+		for j < len(data) && data[j]&0xC0 == 0x80 {
+			j++
+		}
+		if j < len(data) {
+			j++
+		}
+		for k := j - 1; k >= i; k-- {
+			result = append(result, data[k])
+		}
+		i = j
+	}
+	return string(result)
+}
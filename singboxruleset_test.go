@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sagernet/sing-box/common/srs"
+	"github.com/sagernet/sing-box/option"
+	"github.com/sagernet/sing/common/domain"
+)
+
+// testRuleSetJSON rule-set со всеми видами доменов: точный домен, суффикс только поддоменов (".sub"),
+// суффикс без точки, пара домен + суффикс, IDN, ключевое слово и сети
+const testRuleSetJSON = `{
+	"version": 1,
+	"rules": [
+		{
+			"domain": ["exact.org", "example.com", "пример.рф"],
+			"domain_suffix": [".example.com", ".sub.example.net", "bare.io", "испытание.рф"],
+			"domain_keyword": ["ads"],
+			"ip_cidr": ["10.0.0.0/8", "2001:db8::/32"]
+		}
+	]
+}`
+
+// testRuleSetEntries записи, которые должны получиться из testRuleSetJSON
+var testRuleSetEntries = []string{
+	"*.bare.io",
+	"*.example.com",
+	"*.испытание.рф",
+	"domain:exact.org",
+	"domain:keyword:ads",
+	"domain:regexp:^.+\\.sub\\.example\\.net$",
+	"domain:пример.рф",
+	"ip:10.0.0.0/8",
+	"ip:2001:db8::/32",
+}
+
+// parseRuleSetEntries разбирает rule-set и возвращает отсортированные записи с пометкой типа
+func parseRuleSetEntries(t *testing.T, data []byte) []string {
+	t.Helper()
+	var entries []string
+	err := parseSingBoxRuleSet(bytes.NewReader(data), Source{URL: "test"}, func(entry Entry) {
+		switch {
+		case entry.IsIP:
+			entries = append(entries, "ip:"+entry.Value)
+		case strings.HasPrefix(entry.Value, "*."):
+			entries = append(entries, entry.Value)
+		default:
+			entries = append(entries, "domain:"+entry.Value)
+		}
+	})
+	if err != nil {
+		t.Fatalf("cannot parse the rule-set: %v", err)
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+func TestSingBoxRuleSetJSON(t *testing.T) {
+	entries := parseRuleSetEntries(t, []byte(testRuleSetJSON))
+	if fmt.Sprint(entries) != fmt.Sprint(testRuleSetEntries) {
+		t.Errorf("expected %q, got %q", testRuleSetEntries, entries)
+	}
+}
+
+// TestSingBoxRuleSetBinaryRoundTrip компилирует rule-set в .srs средствами sing-box и разбирает его обратно.
+// Домены из .srs извлекаются из внутреннего формата дерева доменов sing, поэтому тест ловит его изменения
+func TestSingBoxRuleSetBinaryRoundTrip(t *testing.T) {
+	var compat option.PlainRuleSetCompat
+	if err := compat.UnmarshalJSON([]byte(testRuleSetJSON)); err != nil {
+		t.Fatal(err)
+	}
+	compat.Upgrade()
+
+	var compiled bytes.Buffer
+	if err := srs.Write(&compiled, compat.Options); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := parseRuleSetEntries(t, compiled.Bytes())
+	if fmt.Sprint(entries) != fmt.Sprint(testRuleSetEntries) {
+		t.Errorf("expected %q, got %q", testRuleSetEntries, entries)
+	}
+}
+
+// TestDumpDomainMatcher разбирает дерево доменов sing напрямую. Если в новой версии sing изменится
+// внутренний формат дерева, тест падает с ошибкой разбора или с другим набором доменов
+func TestDumpDomainMatcher(t *testing.T) {
+	domains := []string{"a.com", "b.a.com", "example.org", "пример.рф"}
+	suffixes := []string{".example.com", "bare.io", "испытание.рф"}
+	matcher := domain.NewMatcher(domains, suffixes)
+
+	gotDomains, gotSuffixes, err := dumpDomainMatcher(matcher)
+	if err != nil {
+		t.Fatalf("the sing domain tree layout has changed: %v", err)
+	}
+	sort.Strings(gotDomains)
+	sort.Strings(gotSuffixes)
+	sort.Strings(suffixes)
+	if fmt.Sprint(gotDomains) != fmt.Sprint(domains) {
+		t.Errorf("expected domains %q, got %q", domains, gotDomains)
+	}
+	if fmt.Sprint(gotSuffixes) != fmt.Sprint(suffixes) {
+		t.Errorf("expected suffixes %q, got %q", suffixes, gotSuffixes)
+	}
+
+	// Восстановленные ключи должны находиться исходным деревом
+	for _, name := range gotDomains {
+		if !matcher.Match(name) {
+			t.Errorf("domain '%s' is not matched by the source tree", name)
+		}
+	}
+	for _, suffix := range gotSuffixes {
+		if !matcher.Match("sub." + strings.TrimPrefix(suffix, ".")) {
+			t.Errorf("suffix '%s' is not matched by the source tree", suffix)
+		}
+	}
+}
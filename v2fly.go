@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// maxV2flyIncludeDepth ограничивает вложенность include: в списках v2fly
+const maxV2flyIncludeDepth = 16
+
+// v2flyAttributeFilter фильтр записей по атрибутам (@cn, @ads, @!cn): атрибуты из required должны быть у записи,
+// атрибутов из excluded у записи быть не должно
+type v2flyAttributeFilter struct {
+	required []string
+	excluded []string
+}
+
+// newV2flyAttributeFilter создаёт фильтр из списка атрибутов вида "cn", "@cn" или "-cn" (без атрибута cn)
+func newV2flyAttributeFilter(attributes []string) v2flyAttributeFilter {
+	var filter v2flyAttributeFilter
+	for _, attribute := range attributes {
+		attribute = strings.TrimPrefix(strings.TrimSpace(attribute), "@")
+		if strings.HasPrefix(attribute, "-") {
+			filter.excluded = append(filter.excluded, attribute[1:])
+		} else if attribute != "" {
+			filter.required = append(filter.required, attribute)
+		}
+	}
+	return filter
+}
+
+// matches проверяет атрибуты записи
+func (f v2flyAttributeFilter) matches(attributes map[string]bool) bool {
+	for _, attribute := range f.required {
+		if !attributes[attribute] {
+			return false
+		}
+	}
+	for _, attribute := range f.excluded {
+		if attributes[attribute] {
+			return false
+		}
+	}
+	return true
+}
+
+// v2flyParser разбирает файл из v2fly/domain-list-community вместе с файлами, подключенными через include:
+type v2flyParser struct {
+	source  Source
+	emit    func(Entry)
+	parents map[string]bool // Файлы, которые сейчас разбираются (защита от циклических include:)
+	invalid int             // Количество пропущенных некорректных строк
+}
+
+// parseV2flyDomainList разбирает файл из v2fly/domain-list-community. Директивы переводятся в записи списков:
+// domain:example.com (и строка без директивы) -> *.example.com, full:example.com -> example.com,
+// keyword:example -> keyword:example, regexp:^ex.*$ -> regexp:^ex.*$. Файлы из include: ищутся рядом
+// с разбираемым файлом. Если в источнике указаны attributes, берутся только записи с этими атрибутами
+func parseV2flyDomainList(input io.Reader, source Source, emit func(Entry)) error {
+	parser := &v2flyParser{source: source, emit: emit, parents: map[string]bool{source.document: true}}
+	filters := []v2flyAttributeFilter{newV2flyAttributeFilter(source.Attributes)}
+	if err := parser.parse(input, source.document, filters, 0); err != nil {
+		return err
+	}
+	if parser.invalid > 0 {
+		logWarn.Printf("source '%s': skipped %d invalid lines", source.URL, parser.invalid)
+	}
+	return nil
+}
+
+// parse разбирает один файл. filters - фильтры по атрибутам файла и всех include:, через которые он подключен
+func (p *v2flyParser) parse(input io.Reader, name string, filters []v2flyAttributeFilter, depth int) error {
+	var includes []string
+	var includeFilters []v2flyAttributeFilter
+
+	err := scanLines(input, func(line string) {
+		// Убираем комментарии
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		// Первое поле - правило, остальные - атрибуты (@attr) и принадлежность (&affiliation)
+		kind, value, found := strings.Cut(fields[0], ":")
+		if !found {
+			kind, value = "domain", fields[0]
+		}
+		attributes := map[string]bool{}
+		var attributeList []string
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "@") {
+				attributes[field[1:]] = true
+				attributeList = append(attributeList, field)
+			}
+		}
+
+		// include: разбираем после текущего файла, атрибуты у include: задают фильтр подключаемых записей
+		if kind == "include" {
+			includes = append(includes, value)
+			includeFilters = append(includeFilters, newV2flyAttributeFilter(attributeList))
+			return
+		}
+
+		for _, filter := range filters {
+			if !filter.matches(attributes) {
+				return
+			}
+		}
+
+		switch kind {
+		case "domain":
+			value = strings.ToLower(value)
+			if !rgxDomain.MatchString(value) {
+				p.invalid++
+				return
+			}
+			p.emit(Entry{Value: "*." + value})
+		case "full":
+			value = strings.ToLower(value)
+			if !rgxDomain.MatchString(value) {
+				p.invalid++
+				return
+			}
+			p.emit(Entry{Value: value})
+		case "keyword":
+			p.emit(Entry{Value: "keyword:" + strings.ToLower(value)})
+		case "regexp":
+			if _, err := regexp.Compile(value); err != nil {
+				logWarn.Printf("invalid regular expression '%s' in '%s': %v", value, name, err)
+				p.invalid++
+				return
+			}
+			p.emit(Entry{Value: "regexp:" + value})
+		default:
+			p.invalid++
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	// Разбираем подключенные файлы
+	for i, include := range includes {
+		if depth+1 >= maxV2flyIncludeDepth {
+			return fmt.Errorf("include: nesting is deeper than %d in '%s'", maxV2flyIncludeDepth, name)
+		}
+		if err := p.include(include, name, append(filters[:len(filters):len(filters)], includeFilters[i]), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// include открывает и разбирает файл, подключенный через include:
+func (p *v2flyParser) include(include, parent string, filters []v2flyAttributeFilter, depth int) error {
+	related := p.source
+	related.document = parent
+	location, err := related.resolveRelated(include)
+	if err != nil {
+		return fmt.Errorf("invalid 'include:%s' in '%s': %v", include, parent, err)
+	}
+	if p.parents[location] {
+		return fmt.Errorf("circular 'include:%s' in '%s'", include, parent)
+	}
+
+	file, err := related.openRelated(location)
+	if err != nil {
+		return fmt.Errorf("cannot open 'include:%s' from '%s': %v", include, parent, err)
+	}
+	defer file.Close()
+
+	p.parents[location] = true
+	defer delete(p.parents, location)
+
+	logInfo.Printf("parsing the included file '%s'...", location)
+	return p.parse(file, location, filters, depth)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Типы проводного формата protobuf
+const (
+	protoVarint = 0
+	protoI64    = 1
+	protoLen    = 2
+	protoI32    = 5
+)
+
+// maxProtoMessageSize ограничивает размер одной записи (категории) в .dat файле
+const maxProtoMessageSize = 1 << 30
+
+// Типы доменов в geosite.dat
+const (
+	v2rayDomainPlain  = 0 // Ключевое слово
+	v2rayDomainRegex  = 1 // Регулярное выражение
+	v2rayDomainSuffix = 2 // Домен и поддомены
+	v2rayDomainFull   = 3 // Только домен
+)
+
+// protoField поле сообщения protobuf
+type protoField struct {
+	Number int    // Номер поля
+	Type   int    // Тип проводного формата
+	Varint uint64 // Значение поля типа varint
+	Bytes  []byte // Значение поля типа len (строка, байты, вложенное сообщение)
+}
+
+// parseProtoFields разбирает поля сообщения protobuf и вызывает handle для каждого поля
+func parseProtoFields(data []byte, handle func(field protoField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid protobuf tag")
+		}
+		data = data[n:]
+		field := protoField{Number: int(tag >> 3), Type: int(tag & 7)}
+
+		switch field.Type {
+		case protoVarint:
+			field.Varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("invalid protobuf varint")
+			}
+			data = data[n:]
+		case protoLen:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return fmt.Errorf("invalid protobuf field length")
+			}
+			field.Bytes = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case protoI64:
+			if len(data) < 8 {
+				return fmt.Errorf("truncated protobuf field")
+			}
+			data = data[8:]
+		case protoI32:
+			if len(data) < 4 {
+				return fmt.Errorf("truncated protobuf field")
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", field.Type)
+		}
+
+		if err := handle(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readProtoListEntries потоково читает сообщение-список (GeoIPList, GeoSiteList): поле 1 - повторяющиеся
+// записи. Записи читаются по одной, чтобы не загружать в память весь файл
+func readProtoListEntries(input io.Reader, handle func(entry []byte) error) error {
+	reader := bufio.NewReader(input)
+	for {
+		tag, err := binary.ReadUvarint(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if tag&7 != protoLen {
+			return fmt.Errorf("invalid .dat file: unexpected field type %d", tag&7)
+		}
+		length, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return err
+		}
+		if length > maxProtoMessageSize {
+			return fmt.Errorf("invalid .dat file: entry of %d bytes", length)
+		}
+		// Неизвестные поля списка пропускаем
+		if tag>>3 != 1 {
+			if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+				return err
+			}
+			continue
+		}
+		entry := make([]byte, length)
+		if _, err := io.ReadFull(reader, entry); err != nil {
+			return err
+		}
+		if err := handle(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// v2rayDatSelection выбранная из .dat файла категория
+type v2rayDatSelection struct {
+	Category   string   // Категория, в которую записываются записи
+	Attributes []string // Атрибуты доменов, которые нужно взять (geosite:google@cn)
+	found      bool     // Код найден в файле
+}
+
+// parseV2rayDat разбирает geoip.dat или geosite.dat V2Ray/Xray. Категории файла выбираются по коду полем
+// categories источника (Например: {"geosite:category-ads-all": "ads"}); префикс "geoip:"/"geosite:"
+// необязателен, после "@" можно указать атрибут доменов. Тип файла определяется по содержимому
+func parseV2rayDat(input io.Reader, source Source, emit func(Entry)) error {
+	if len(source.Categories) == 0 {
+		return fmt.Errorf("'categories' is required to select categories from a .dat file")
+	}
+
+	// Группируем выбранные коды: один код может попадать в несколько категорий
+	selections := map[string][]*v2rayDatSelection{}
+	var codes []string
+	for key, category := range source.Categories {
+		code := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(key), "geoip:"), "geosite:")
+		code, attributes, _ := strings.Cut(code, "@")
+		selection := &v2rayDatSelection{Category: category}
+		if attributes != "" {
+			selection.Attributes = strings.Split(attributes, "@")
+		}
+		if selections[code] == nil {
+			codes = append(codes, code)
+		}
+		selections[code] = append(selections[code], selection)
+	}
+
+	err := readProtoListEntries(input, func(entry []byte) error {
+		// Ищем код записи (country_code - поле 1)
+		var code string
+		err := parseProtoFields(entry, func(field protoField) error {
+			if field.Number == 1 && field.Type == protoLen {
+				code = strings.ToLower(string(field.Bytes))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(selections[code]) == 0 {
+			return nil
+		}
+		for _, selection := range selections[code] {
+			selection.found = true
+		}
+		return emitV2rayDatEntry(entry, code, selections[code], emit)
+	})
+	if err != nil {
+		return fmt.Errorf("invalid .dat file: %v", err)
+	}
+
+	// Опечатка в коде не должна незаметно давать пустую категорию
+	var missing []string
+	for _, code := range codes {
+		if !selections[code][0].found {
+			missing = append(missing, code)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("codes not found in the .dat file: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// emitV2rayDatEntry передаёт в emit домены (GeoSite) или сети (GeoIP) записи .dat файла. Список (поле 2)
+// у обоих форматов, а тип определяется по первому полю элемента: у CIDR это байты адреса
+func emitV2rayDatEntry(entry []byte, code string, selections []*v2rayDatSelection, emit func(Entry)) error {
+	// GeoIP.inverse_match (поле 3) означает совпадение со всем, кроме сетей категории, - списком это не выразить
+	err := parseProtoFields(entry, func(field protoField) error {
+		if field.Number == 3 && field.Type == protoVarint && field.Varint != 0 {
+			return fmt.Errorf("the category '%s' uses inverse_match, which cannot be imported as a list", code)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return parseProtoFields(entry, func(field protoField) error {
+		if field.Number != 2 || field.Type != protoLen {
+			return nil
+		}
+		if len(field.Bytes) > 0 && field.Bytes[0] == 1<<3|protoLen {
+			return emitV2rayCIDR(field.Bytes, selections, emit)
+		}
+		return emitV2rayDomain(field.Bytes, selections, emit)
+	})
+}
+
+// emitV2rayCIDR передаёт в emit сеть из сообщения CIDR (ip - поле 1, prefix - поле 2)
+func emitV2rayCIDR(message []byte, selections []*v2rayDatSelection, emit func(Entry)) error {
+	var ip net.IP
+	var prefix uint64
+	err := parseProtoFields(message, func(field protoField) error {
+		switch {
+		case field.Number == 1 && field.Type == protoLen:
+			ip = net.IP(field.Bytes)
+		case field.Number == 2 && field.Type == protoVarint:
+			prefix = field.Varint
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len || prefix > uint64(len(ip)*8) {
+		return fmt.Errorf("invalid CIDR")
+	}
+
+	network := net.IPNet{IP: ip, Mask: net.CIDRMask(int(prefix), len(ip)*8)}
+	for _, selection := range selections {
+		// Атрибуты есть только у доменов
+		if len(selection.Attributes) == 0 {
+			emit(Entry{Value: network.String(), IsIP: true, Category: selection.Category})
+		}
+	}
+	return nil
+}
+
+// emitV2rayDomain передаёт в emit домен из сообщения Domain (type - поле 1, value - поле 2, attribute - поле 3)
+func emitV2rayDomain(message []byte, selections []*v2rayDatSelection, emit func(Entry)) error {
+	var domainType uint64
+	var value string
+	attributes := map[string]bool{}
+	err := parseProtoFields(message, func(field protoField) error {
+		switch {
+		case field.Number == 1 && field.Type == protoVarint:
+			domainType = field.Varint
+		case field.Number == 2 && field.Type == protoLen:
+			value = string(field.Bytes)
+		case field.Number == 3 && field.Type == protoLen:
+			// Атрибут: key - поле 1
+			return parseProtoFields(field.Bytes, func(attribute protoField) error {
+				if attribute.Number == 1 && attribute.Type == protoLen {
+					attributes[strings.ToLower(string(attribute.Bytes))] = true
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var entryValue string
+	switch domainType {
+	case v2rayDomainPlain:
+		entryValue = "keyword:" + strings.ToLower(value)
+	case v2rayDomainRegex:
+		entryValue = "regexp:" + value
+	case v2rayDomainSuffix:
+		entryValue = "*." + strings.ToLower(value)
+	case v2rayDomainFull:
+		entryValue = strings.ToLower(value)
+	default:
+		return fmt.Errorf("unknown domain type %d", domainType)
+	}
+
+	for _, selection := range selections {
+		if newV2flyAttributeFilter(selection.Attributes).matches(attributes) {
+			emit(Entry{Value: entryValue, Category: selection.Category})
+		}
+	}
+	return nil
+}